@@ -1,73 +1,301 @@
 package actions
 
-import "fmt"
+import (
+	"fmt"
 
-const (
-	UbuntuRunner = "ubuntu-20.04"
+	"gopkg.in/yaml.v2"
 )
 
 type Workflow struct {
-	Name string
-	On   Trigger
-	Jobs map[string]Job
+	Name        string
+	RunName     string `yaml:"run-name,omitempty"`
+	On          Trigger
+	Permissions Permissions       `yaml:",omitempty"`
+	Env         map[string]string `yaml:",omitempty"`
+	Concurrency *Concurrency      `yaml:",omitempty"`
+	Defaults    *Defaults         `yaml:",omitempty"`
+	Jobs        map[string]Job
 }
 
+// ParseWorkflow parses workflow definition. Keys not modelled by the types in
+// this package are ignored.
+func ParseWorkflow(data []byte) (Workflow, error) {
+	w := Workflow{}
+	if err := yaml.Unmarshal(data, &w); err != nil {
+		return Workflow{}, err
+	}
+	return w, nil
+}
+
+// ParseWorkflowStrict is like ParseWorkflow, but fails on keys which are not
+// modelled.
+func ParseWorkflowStrict(data []byte) (Workflow, error) {
+	w := Workflow{}
+	if err := yaml.UnmarshalStrict(data, &w); err != nil {
+		return Workflow{}, err
+	}
+	return w, nil
+}
+
+// Validate checks structural correctness of the workflow and fails on errors
+// reported by Lint.
 func (w Workflow) Validate() error {
+	if err := w.Permissions.Validate(); err != nil {
+		return fmt.Errorf("invalid workflow permissions: %w", err)
+	}
 	for jobName, job := range w.Jobs {
 		jobErr := job.Validate()
 		if job.Name != "" && job.Name != jobName {
 			jobErr = fmt.Errorf("job name mismatch: named in map as %s, but name is %s", jobName, job.Name)
 		}
+		for _, dep := range job.Needs {
+			if _, ok := w.Jobs[dep]; jobErr == nil && !ok {
+				jobErr = fmt.Errorf("depends on unknown job %s", dep)
+			}
+		}
 		if jobErr != nil {
 			return fmt.Errorf("invalid job %s: %w", jobName, jobErr)
 		}
 	}
+	for _, f := range w.Lint() {
+		if f.Severity == SeverityError {
+			return f
+		}
+	}
 	return nil
 }
 
 type Trigger struct {
-	PullRequest EmptyStruct `yaml:"pull_request"`
-	Push        PushTrigger
+	PullRequest       *PullRequestTrigger `yaml:"pull_request,omitempty"`
+	PullRequestTarget *PullRequestTrigger `yaml:"pull_request_target,omitempty"`
+	Push              *PushTrigger        `yaml:",omitempty"`
+	MergeGroup        *MergeGroupTrigger  `yaml:"merge_group,omitempty"`
+	// Other contains events not modelled explicitly
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML accepts all forms of `on`: a single event name, a list of
+// event names and a mapping from event names to their configuration.
+func (t *Trigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var events []string
+	var event string
+	if err := unmarshal(&event); err == nil {
+		events = []string{event}
+	} else if err := unmarshal(&events); err != nil {
+		type plain Trigger
+		var p plain
+		if err := unmarshal(&p); err != nil {
+			return err
+		}
+		*t = Trigger(p)
+		return nil
+	}
+	*t = Trigger{}
+	for _, e := range events {
+		switch e {
+		case "pull_request":
+			t.PullRequest = &PullRequestTrigger{}
+		case "pull_request_target":
+			t.PullRequestTarget = &PullRequestTrigger{}
+		case "push":
+			t.Push = &PushTrigger{}
+		case "merge_group":
+			t.MergeGroup = &MergeGroupTrigger{}
+		default:
+			if t.Other == nil {
+				t.Other = make(map[string]interface{})
+			}
+			t.Other[e] = nil
+		}
+	}
+	return nil
+}
+
+// Has checks if workflow is triggered by the event.
+func (t Trigger) Has(event string) bool {
+	switch event {
+	case "pull_request":
+		return t.PullRequest != nil
+	case "pull_request_target":
+		return t.PullRequestTarget != nil
+	case "push":
+		return t.Push != nil
+	case "merge_group":
+		return t.MergeGroup != nil
+	}
+	_, ok := t.Other[event]
+	return ok
+}
+
+type PullRequestTrigger struct {
+	Branches       []string `yaml:",omitempty"`
+	BranchesIgnore []string `yaml:"branches-ignore,omitempty"`
+	Paths          []string `yaml:",omitempty"`
+	PathsIgnore    []string `yaml:"paths-ignore,omitempty"`
+	Types          []string `yaml:",omitempty"`
 }
 
 type PushTrigger struct {
-	Branches []string
+	Branches       []string `yaml:",omitempty"`
+	BranchesIgnore []string `yaml:"branches-ignore,omitempty"`
+	Tags           []string `yaml:",omitempty"`
+	TagsIgnore     []string `yaml:"tags-ignore,omitempty"`
+	Paths          []string `yaml:",omitempty"`
+	PathsIgnore    []string `yaml:"paths-ignore,omitempty"`
 }
 
-type EmptyStruct struct{}
+// MergeGroupTrigger runs workflow on merge queue candidates.
+type MergeGroupTrigger struct {
+	Types []string `yaml:"types,omitempty"`
+}
+
+// StringList is a list of strings, which can be written in YAML as a single
+// string if it has one element.
+type StringList []string
+
+func (l StringList) MarshalYAML() (interface{}, error) {
+	if len(l) == 1 {
+		return l[0], nil
+	}
+	return []string(l), nil
+}
+
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
 
 type Job struct {
-	Name    string            `yaml:",omitempty"`
-	If      string            `yaml:",omitempty"`
-	Needs   string            `yaml:",omitempty"`
-	Env     map[string]string `yaml:",omitempty"`
-	RunsOn  string            `yaml:"runs-on"`
-	Timeout int               `yaml:"timeout-minutes"`
-	Steps   []Step            `yaml:"steps"`
+	Name            string               `yaml:",omitempty"`
+	If              string               `yaml:",omitempty"`
+	Needs           StringList           `yaml:",omitempty"`
+	Permissions     Permissions          `yaml:",omitempty"`
+	Env             map[string]string    `yaml:",omitempty"`
+	RunsOn          Runner               `yaml:"runs-on,omitempty"`
+	Timeout         int                  `yaml:"timeout-minutes,omitempty"`
+	Strategy        *Strategy            `yaml:",omitempty"`
+	Outputs         map[string]string    `yaml:",omitempty"`
+	Steps           []Step               `yaml:"steps,omitempty"`
+	Container       *Container           `yaml:",omitempty"`
+	Services        map[string]Container `yaml:",omitempty"`
+	ContinueOnError bool                 `yaml:"continue-on-error,omitempty"`
+	Concurrency     *Concurrency         `yaml:",omitempty"`
+	Environment     *Environment         `yaml:",omitempty"`
+	Defaults        *Defaults            `yaml:",omitempty"`
+	// Uses references reusable workflow. Such jobs have no steps.
+	Uses string            `yaml:",omitempty"`
+	With map[string]string `yaml:",omitempty"`
+	// Secrets passed to reusable workflow, either `inherit` or a mapping
+	Secrets interface{} `yaml:",omitempty"`
+	// Comment is emitted above the job, e.g. to explain why it exists
+	Comment string `yaml:"-"`
+	// Provenance lists reasons the generator created or changed the job
+	Provenance []string `yaml:"-"`
+}
+
+// CheckName returns name of the check run reported by the job with the given
+// key.
+func (j Job) CheckName(key string) string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return key
 }
 
 func (j Job) Validate() error {
-	if j.RunsOn == "" {
-		return fmt.Errorf("missing runs-on")
+	// runner and timeout of reusable workflow jobs are set by the workflow
+	if j.Uses == "" {
+		if err := j.RunsOn.Validate(); err != nil {
+			return err
+		}
+		if j.Timeout == 0 {
+			return fmt.Errorf("missing timeout-minutes")
+		}
+	}
+	if err := j.Permissions.Validate(); err != nil {
+		return err
 	}
-	if j.Timeout == 0 {
-		return fmt.Errorf("missing timeout-minutes")
+	if j.Permissions == nil && j.usesGithubToken() {
+		return fmt.Errorf("uses secrets.GITHUB_TOKEN, but does not declare permissions")
 	}
 	return nil
 }
 
+func (j Job) usesGithubToken() bool {
+	if anyMatches(j.Env, referencesGithubToken) {
+		return true
+	}
+	for _, step := range j.Steps {
+		if step.matches(referencesGithubToken) {
+			return true
+		}
+	}
+	return false
+}
+
+type Strategy struct {
+	Matrix      Matrix `yaml:"matrix"`
+	FailFast    *bool  `yaml:"fail-fast,omitempty"`
+	MaxParallel int    `yaml:"max-parallel,omitempty"`
+}
+
+// Matrix describes job variants. Values are combined as cartesian product,
+// then Include entries are added.
+type Matrix struct {
+	Values  map[string][]string `yaml:",inline"`
+	Include []map[string]string `yaml:",omitempty"`
+	Exclude []map[string]string `yaml:",omitempty"`
+}
+
 type Step struct {
-	Id   string            `yaml:",omitempty"`
-	Name string            `yaml:",omitempty"`
-	If   string            `yaml:",omitempty"`
-	Uses string            `yaml:",omitempty"`
-	Run  string            `yaml:",omitempty"`
-	With map[string]string `yaml:",omitempty"`
+	Id    string            `yaml:",omitempty"`
+	Name  string            `yaml:",omitempty"`
+	If    string            `yaml:",omitempty"`
+	Uses  string            `yaml:",omitempty"`
+	Run   string            `yaml:",omitempty"`
+	Shell string            `yaml:",omitempty"`
+	With  map[string]string `yaml:",omitempty"`
+	Env   map[string]string `yaml:",omitempty"`
+
+	WorkingDirectory string `yaml:"working-directory,omitempty"`
+	ContinueOnError  bool   `yaml:"continue-on-error,omitempty"`
+	Timeout          int    `yaml:"timeout-minutes,omitempty"`
+	// Comment is emitted above the step
+	Comment string `yaml:"-"`
+}
+
+// matches checks if pred holds for some string the step passes to the
+// runner: script, action inputs or environment.
+func (s Step) matches(pred func(string) bool) bool {
+	return pred(s.Run) || anyMatches(s.With, pred) || anyMatches(s.Env, pred)
+}
+
+func anyMatches(m map[string]string, pred func(string) bool) bool {
+	for _, v := range m {
+		if pred(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bool returns pointer to b, for use in optional fields.
+func Bool(b bool) *bool {
+	return &b
 }
 
 func MakeCheckoutStep() Step {
 	return Step{
 		Name: "Fetch sources",
-		Uses: "actions/checkout@v2",
+		Uses: Checkout.Ref(),
 	}
 }
@@ -0,0 +1,67 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestJobUsingTokenRequiresPermissions(t *testing.T) {
+	job := Job{
+		RunsOn:  HostedRunner(DefaultRunnerImage),
+		Timeout: 1,
+		Steps: []Step{
+			{
+				Uses: Misspell.Ref(),
+				With: map[string]string{"github_token": "${{ secrets.GITHUB_TOKEN }}"},
+			},
+		},
+	}
+	assert.ErrorContains(t, job.Validate(), "does not declare permissions")
+
+	job.Permissions = Permissions{"pull-requests": PermissionWrite}
+	assert.NilError(t, job.Validate())
+
+	job.Permissions = Permissions{"pull-requests": "admin"}
+	assert.ErrorContains(t, job.Validate(), "invalid access level")
+}
+
+func TestCheckNamesExpandMatrix(t *testing.T) {
+	job := Job{
+		Strategy: &Strategy{Matrix: Matrix{
+			Values:  map[string][]string{"os": {"linux", "macos"}, "go": {"1.16"}},
+			Include: []map[string]string{{"os": "linux", "race": "true"}, {"os": "windows", "go": "1.17"}},
+		}},
+	}
+	names, err := job.CheckNames("test")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"test (1.16, linux, true)", "test (1.16, macos)", "test (1.17, windows)"})
+
+	job.Name = "test on ${{ matrix.os }}"
+	job.Strategy.Matrix.Include = nil
+	names, err = job.CheckNames("test")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"test on linux", "test on macos"})
+}
+
+func TestPermissionShorthands(t *testing.T) {
+	w, err := ParseWorkflow([]byte("on: push\npermissions: read-all\njobs:\n  a:\n    permissions: {}\n    runs-on: ubuntu-22.04\n  b:\n    runs-on: ubuntu-22.04\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, w.Permissions["contents"], PermissionRead)
+	assert.Equal(t, w.Permissions["id-token"], "")
+	assert.Assert(t, w.Jobs["a"].Permissions != nil)
+	assert.Equal(t, len(w.Jobs["a"].Permissions), 0)
+
+	data, err := w.Marshal()
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), "permissions: read-all\n"), string(data))
+	roundTrip, err := ParseWorkflow(data)
+	assert.NilError(t, err)
+	assert.Assert(t, roundTrip.Jobs["a"].Permissions != nil, string(data))
+	assert.Equal(t, len(roundTrip.Jobs["a"].Permissions), 0)
+	assert.Assert(t, roundTrip.Jobs["b"].Permissions == nil, string(data))
+
+	_, err = ParseWorkflow([]byte("on: push\npermissions: admin\n"))
+	assert.ErrorContains(t, err, "invalid permissions")
+}
@@ -0,0 +1,151 @@
+package actions
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// combination is a single job of the expanded matrix.
+type combination struct {
+	keys   []string
+	values map[string]string
+}
+
+func (c combination) copy() combination {
+	res := combination{keys: append([]string{}, c.keys...), values: make(map[string]string)}
+	for k, v := range c.values {
+		res.values[k] = v
+	}
+	return res
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expand returns all combinations of the matrix, following GitHub rules for
+// `include`: an entry extends every combination it does not conflict with,
+// and is added as a new combination if it extends none.
+func (m Matrix) expand() ([]combination, error) {
+	keys := make([]string, 0, len(m.Values))
+	for k, values := range m.Values {
+		for _, v := range values {
+			if IsExpression(v) {
+				return nil, fmt.Errorf("matrix %s is computed at runtime", k)
+			}
+		}
+		keys = append(keys, k)
+	}
+	// serialized matrix has sorted keys, so GitHub sees them in this order
+	sort.Strings(keys)
+	combinations := []combination{{values: make(map[string]string)}}
+	for _, k := range keys {
+		next := make([]combination, 0)
+		for _, c := range combinations {
+			for _, v := range m.Values[k] {
+				n := c.copy()
+				n.keys = append(n.keys, k)
+				n.values[k] = v
+				next = append(next, n)
+			}
+		}
+		combinations = next
+	}
+	if len(keys) == 0 {
+		combinations = nil
+	}
+	for _, entry := range m.Include {
+		extended := false
+		for i, c := range combinations {
+			conflicts := false
+			for k, v := range entry {
+				if _, original := m.Values[k]; original && c.values[k] != v {
+					conflicts = true
+				}
+			}
+			if conflicts {
+				continue
+			}
+			extended = true
+			for _, k := range sortedKeys(entry) {
+				if _, ok := c.values[k]; !ok {
+					combinations[i].keys = append(combinations[i].keys, k)
+				}
+				combinations[i].values[k] = entry[k]
+			}
+		}
+		if !extended {
+			combinations = append(combinations, combination{keys: sortedKeys(entry), values: entry}.copy())
+		}
+	}
+	return combinations, nil
+}
+
+var matrixRefRegexp = regexp.MustCompile(`\$\{\{\s*matrix\.([A-Za-z0-9_-]+)\s*\}\}`)
+
+// CheckNames returns names of check runs reported by the job with the given
+// key. Matrix jobs report a check per combination: either the name with
+// matrix references substituted, or the name followed by matrix values.
+func (j Job) CheckNames(key string) ([]string, error) {
+	if j.Uses != "" {
+		return nil, fmt.Errorf("job %s calls reusable workflow, its check names are not known", key)
+	}
+	name := j.CheckName(key)
+	if j.Strategy == nil {
+		return []string{name}, nil
+	}
+	combinations, err := j.Strategy.Matrix.expand()
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %w", key, err)
+	}
+	if len(combinations) == 0 {
+		return []string{name}, nil
+	}
+	names := make([]string, 0, len(combinations))
+	for _, c := range combinations {
+		if matrixRefRegexp.MatchString(name) {
+			names = append(names, matrixRefRegexp.ReplaceAllStringFunc(name, func(ref string) string {
+				return c.values[matrixRefRegexp.FindStringSubmatch(ref)[1]]
+			}))
+			continue
+		}
+		values := make([]string, 0, len(c.keys))
+		for _, k := range c.keys {
+			values = append(values, c.values[k])
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", name, strings.Join(values, ", ")))
+	}
+	return names, nil
+}
+
+// ResolveCheckNames returns check names reported by jobs with the given keys
+// in any of the workflows.
+func ResolveCheckNames(workflows []Workflow, jobKeys []string) ([]string, error) {
+	names := make([]string, 0, len(jobKeys))
+	for _, key := range jobKeys {
+		found := false
+		for _, w := range workflows {
+			job, ok := w.Jobs[key]
+			if !ok {
+				continue
+			}
+			found = true
+			jobNames, err := job.CheckNames(key)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, jobNames...)
+		}
+		if !found {
+			return nil, fmt.Errorf("job %s not found in generated workflows", key)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,131 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OrderJobs returns job names so that every job goes after jobs it needs,
+// and independent jobs are sorted by name.
+func OrderJobs(jobs map[string]Job) []string {
+	done := make(map[string]bool)
+	order := make([]string, 0, len(jobs))
+	for len(order) < len(jobs) {
+		ready := ""
+		for name, job := range jobs {
+			if done[name] || (ready != "" && name > ready) {
+				continue
+			}
+			blocked := false
+			for _, need := range job.Needs {
+				if _, ok := jobs[need]; ok && !done[need] {
+					blocked = true
+				}
+			}
+			if !blocked {
+				ready = name
+			}
+		}
+		if ready == "" {
+			// dependency cycle, which Validate reports; keep output stable
+			for name := range jobs {
+				if !done[name] && (ready == "" || name < ready) {
+					ready = name
+				}
+			}
+		}
+		done[ready] = true
+		order = append(order, ready)
+	}
+	return order
+}
+
+// MappingValue returns value stored under key in the mapping node, or nil.
+func MappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// useLiteralScripts renders multi-line `run` scripts as literal blocks.
+func useLiteralScripts(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "run" && value.Kind == yaml.ScalarNode && strings.Contains(value.Value, "\n") {
+				value.Style = yaml.LiteralStyle
+			}
+		}
+	}
+	for _, c := range n.Content {
+		useLiteralScripts(c)
+	}
+}
+
+func commentLines(comment string) string {
+	lines := strings.Split(strings.TrimSpace(comment), "\n")
+	for i, l := range lines {
+		lines[i] = "# " + l
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Marshal serializes workflow for humans: jobs are ordered by dependencies,
+// multi-line scripts are literal blocks and comments of jobs and steps are
+// preserved.
+func (w Workflow) Marshal() ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(w); err != nil {
+		return nil, err
+	}
+	for _, key := range root.Content {
+		if key.Value == "on" {
+			// yaml.v3 quotes `on` for YAML 1.1 parsers, GitHub reads it as a key
+			key.Style = 0
+		}
+	}
+	jobsNode := MappingValue(&root, "jobs")
+	if jobsNode == nil {
+		return nil, fmt.Errorf("workflow has no jobs")
+	}
+	keys := make(map[string]*yaml.Node)
+	values := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(jobsNode.Content); i += 2 {
+		keys[jobsNode.Content[i].Value] = jobsNode.Content[i]
+		values[jobsNode.Content[i].Value] = jobsNode.Content[i+1]
+	}
+	content := make([]*yaml.Node, 0, len(jobsNode.Content))
+	for _, name := range OrderJobs(w.Jobs) {
+		job := w.Jobs[name]
+		if job.Comment != "" {
+			keys[name].HeadComment = commentLines(job.Comment)
+		}
+		if steps := MappingValue(values[name], "steps"); steps != nil {
+			for i, step := range job.Steps {
+				if step.Comment != "" && i < len(steps.Content) {
+					steps.Content[i].HeadComment = commentLines(step.Comment)
+				}
+			}
+		}
+		content = append(content, keys[name], values[name])
+	}
+	jobsNode.Content = content
+	useLiteralScripts(&root)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestMarshalIsHumanOrdered(t *testing.T) {
+	w := Workflow{
+		Name: "ci",
+		On:   Trigger{Push: &PushTrigger{}},
+		Jobs: map[string]Job{
+			"a-deploy": {Needs: StringList{"z-build"}, Comment: "Runs after build"},
+			"z-build":  {Steps: []Step{{Name: "build", Run: "make\nmake install"}}},
+			"b-lint":   {},
+		},
+	}
+	data, err := w.Marshal()
+	assert.NilError(t, err)
+	s := string(data)
+	assert.Assert(t, strings.Contains(s, "\non:\n"), s)
+	assert.Assert(t, strings.Index(s, "b-lint:") < strings.Index(s, "z-build:"), s)
+	assert.Assert(t, strings.Index(s, "z-build:") < strings.Index(s, "a-deploy:"), s)
+	assert.Assert(t, strings.Contains(s, "  # Runs after build\n  a-deploy:"), s)
+	assert.Assert(t, strings.Contains(s, "run: |-\n          make\n          make install"), s)
+
+	parsed, err := ParseWorkflow(data)
+	assert.NilError(t, err)
+	assert.Equal(t, parsed.Jobs["z-build"].Steps[0].Run, "make\nmake install")
+}
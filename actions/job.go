@@ -0,0 +1,80 @@
+package actions
+
+// Container describes docker container used as job environment or as a
+// service.
+type Container struct {
+	Image       string            `yaml:"image"`
+	Credentials map[string]string `yaml:",omitempty"`
+	Env         map[string]string `yaml:",omitempty"`
+	Ports       []string          `yaml:",omitempty"`
+	Volumes     []string          `yaml:",omitempty"`
+	Options     string            `yaml:",omitempty"`
+}
+
+// UnmarshalYAML accepts both image name and full container definition.
+func (c *Container) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var image string
+	if err := unmarshal(&image); err == nil {
+		*c = Container{Image: image}
+		return nil
+	}
+	type plain Container
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = Container(p)
+	return nil
+}
+
+type Concurrency struct {
+	Group            string `yaml:"group"`
+	CancelInProgress bool   `yaml:"cancel-in-progress,omitempty"`
+}
+
+// UnmarshalYAML accepts both group name and full definition.
+func (c *Concurrency) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var group string
+	if err := unmarshal(&group); err == nil {
+		*c = Concurrency{Group: group}
+		return nil
+	}
+	type plain Concurrency
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = Concurrency(p)
+	return nil
+}
+
+// Environment is a deployment environment of the job.
+type Environment struct {
+	Name string `yaml:"name"`
+	Url  string `yaml:"url,omitempty"`
+}
+
+// UnmarshalYAML accepts both environment name and full definition.
+func (e *Environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*e = Environment{Name: name}
+		return nil
+	}
+	type plain Environment
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*e = Environment(p)
+	return nil
+}
+
+type Defaults struct {
+	Run RunDefaults `yaml:"run"`
+}
+
+type RunDefaults struct {
+	Shell            string `yaml:",omitempty"`
+	WorkingDirectory string `yaml:"working-directory,omitempty"`
+}
@@ -0,0 +1,158 @@
+package actions
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a problem reported by Lint.
+type Finding struct {
+	Severity Severity
+	Rule     string
+	Job      string
+	// Step is name (or index if step has no name) of the step
+	Step    string
+	Message string
+}
+
+func (f Finding) Error() string {
+	location := ""
+	if f.Job != "" {
+		location = "job " + f.Job
+		if f.Step != "" {
+			location += ", step " + f.Step
+		}
+		location += ": "
+	}
+	return fmt.Sprintf("[%s] %s: %s%s", f.Severity, f.Rule, location, f.Message)
+}
+
+var (
+	expressionRegexp = regexp.MustCompile(`(?s)\${{(.*?)}}`)
+	// untrustedFieldRegexp matches context fields which can be set to
+	// arbitrary text by the author of a pull request, issue or commit.
+	untrustedFieldRegexp = regexp.MustCompile(`github\.head_ref\b|github\.event\.[A-Za-z0-9_.*\[\]]*?` +
+		`(\.title|\.body|\.message|\.page_name|\.head_branch|\.head\.ref|\.head\.label|\.head\.repo\.default_branch|` +
+		`\.(author|committer)\.(name|email)|\.labels?\.(\*\.|\d+\.|\[\d+\]\.)?name)\b`)
+	secretRegexp    = regexp.MustCompile(`\${{[^}]*secrets\.([A-Za-z_][A-Za-z0-9_]*)[^}]*}}`)
+	prHeadRefRegexp = regexp.MustCompile(`github\.event\.pull_request\.head|github\.head_ref`)
+	alwaysRegexp    = regexp.MustCompile(`always\(\)`)
+	// forkGuardRegexp matches conditions which skip runs for forks
+	forkGuardRegexp = regexp.MustCompile(`github\.event\.(pull_request\.head\.repo|workflow_run\.head_repository)\.full_name\s*==\s*github\.repository\b|` +
+		`github\.repository\s*==\s*github\.event\.(pull_request\.head\.repo|workflow_run\.head_repository)\.full_name\b`)
+)
+
+// githubScriptRepo is the action running `with.script` as JavaScript.
+const githubScriptRepo = "actions/github-script"
+
+// interpolatesUntrustedInput checks if s contains expression expanding to
+// attacker-controlled text.
+func interpolatesUntrustedInput(s string) bool {
+	for _, m := range expressionRegexp.FindAllStringSubmatch(s, -1) {
+		if untrustedFieldRegexp.MatchString(m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func referencesSecrets(s string) bool {
+	return secretRegexp.MatchString(s)
+}
+
+// referencesUserSecrets checks for secrets other than GITHUB_TOKEN.
+func referencesUserSecrets(s string) bool {
+	for _, m := range secretRegexp.FindAllStringSubmatch(s, -1) {
+		if m[1] != "GITHUB_TOKEN" {
+			return true
+		}
+	}
+	return false
+}
+
+func stepName(i int, step Step) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Lint reports security and reliability problems of the workflow.
+func (w Workflow) Lint() []Finding {
+	findings := make([]Finding, 0)
+	report := func(sev Severity, rule, job, step, format string, args ...interface{}) {
+		findings = append(findings, Finding{
+			Severity: sev,
+			Rule:     rule,
+			Job:      job,
+			Step:     step,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	// Workflows triggered by these events run with write token and secrets
+	// even for pull requests from forks.
+	privileged := w.On.Has("pull_request_target") || w.On.Has("workflow_run")
+	if w.On.Has("pull_request_target") {
+		report(SeverityWarning, "pull-request-target", "", "", "workflow is triggered by pull_request_target, which grants write access to pull requests from forks")
+	}
+	if privileged && anyMatches(w.Env, referencesUserSecrets) {
+		report(SeverityError, "fork-secrets", "", "", "workflow-level env exposes secrets to untrusted pull requests")
+	}
+
+	jobNames := make([]string, 0, len(w.Jobs))
+	for jobName := range w.Jobs {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+
+	for _, jobName := range jobNames {
+		job := w.Jobs[jobName]
+		if job.Uses == "" && job.Timeout == 0 {
+			report(SeverityWarning, "missing-timeout", jobName, "", "timeout-minutes is not set, job may run for 6 hours")
+		}
+		if a, ok := ParseActionRef(job.Uses); ok && !IsCommitSha(a.Tag) {
+			report(SeverityWarning, "unpinned-action", jobName, "", "reusable workflow %s is not pinned to a commit sha", job.Uses)
+		}
+		checksOutPrHead := false
+		jobUsesSecrets := anyMatches(job.Env, referencesUserSecrets)
+		for i, step := range job.Steps {
+			name := stepName(i, step)
+			script := step.Run
+			if a, ok := ParseActionRef(step.Uses); ok && a.Repo == githubScriptRepo {
+				script = step.With["script"]
+			}
+			if interpolatesUntrustedInput(script) {
+				report(SeverityError, "script-injection", jobName, name, "untrusted input is interpolated into script, pass it through env instead")
+			}
+			if a, ok := ParseActionRef(step.Uses); ok {
+				if !IsCommitSha(a.Tag) {
+					report(SeverityWarning, "unpinned-action", jobName, name, "%s is not pinned to a commit sha", step.Uses)
+				}
+				if a.Repo == Checkout.Repo && prHeadRefRegexp.MatchString(step.With["ref"]) {
+					checksOutPrHead = true
+				}
+			}
+			if step.matches(referencesUserSecrets) {
+				jobUsesSecrets = true
+			}
+			if alwaysRegexp.MatchString(step.If) && (step.matches(referencesSecrets) || anyMatches(job.Env, referencesSecrets)) {
+				report(SeverityWarning, "always-with-secrets", jobName, name, "step with always() has access to secrets and runs even if previous steps failed or were cancelled")
+			}
+		}
+		if w.On.Has("pull_request_target") && checksOutPrHead {
+			report(SeverityError, "pull-request-target", jobName, "", "job checks out pull request code in privileged pull_request_target context")
+		}
+		if privileged && jobUsesSecrets && !forkGuardRegexp.MatchString(job.If) {
+			report(SeverityError, "fork-secrets", jobName, "", "job exposes secrets to pull requests from forks, guard it with `if: github.event.pull_request.head.repo.full_name == github.repository`")
+		}
+	}
+	return findings
+}
@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+const insecureWorkflow = `
+on: [pull_request_target, issues]
+jobs:
+  greet:
+    runs-on: ubuntu-latest
+    env:
+      DEPLOY_KEY: ${{ secrets.DEPLOY_KEY }}
+    steps:
+    - uses: actions/checkout@v2
+      with:
+        ref: ${{ github.event.pull_request.head.sha }}
+    - name: Greet
+      run: echo "${{ github.event.pull_request.title }}"
+    - name: Report
+      if: always()
+      run: ./report.sh
+`
+
+func TestLintHandWrittenWorkflow(t *testing.T) {
+	w, err := ParseWorkflow([]byte(insecureWorkflow))
+	assert.NilError(t, err)
+	assert.Assert(t, w.On.Has("issues"))
+
+	rules := make(map[string]Severity)
+	for _, f := range w.Lint() {
+		if f.Job != "" {
+			rules[f.Rule] = f.Severity
+		}
+	}
+	assert.DeepEqual(t, rules, map[string]Severity{
+		"missing-timeout":     SeverityWarning,
+		"unpinned-action":     SeverityWarning,
+		"script-injection":    SeverityError,
+		"always-with-secrets": SeverityWarning,
+		"pull-request-target": SeverityError,
+		"fork-secrets":        SeverityError,
+	})
+	assert.ErrorContains(t, w.Validate(), "missing timeout-minutes")
+}
+
+func TestScriptInjectionOnlyForUntrustedFields(t *testing.T) {
+	for expr, untrusted := range map[string]bool{
+		"github.event.pull_request.number":                false,
+		"github.event.pull_request.head.sha":              false,
+		"github.event.repository.name":                    false,
+		"github.event.pull_request.title":                 true,
+		"github.event.issue.body":                         true,
+		"github.head_ref":                                 true,
+		"github.event.pull_request.head.ref":              true,
+		"github.event.head_commit.message":                true,
+		"github.event.commits[0].author.email":            true,
+		"github.event.pull_request.labels.*.name":         true,
+		"toJSON(github.event.comment.body)":               true,
+		"github.event.workflow_run.head_branch":           true,
+		"github.event.pull_request.head.repo.fork":        false,
+		"format('{0}', github.event.review.body)":         true,
+		"github.event.pull_request.requested_teams":       false,
+		"github.event.discussion.category.slug":           false,
+		"github.event.pull_request.head.repo.owner.login": false,
+	} {
+		assert.Equal(t, interpolatesUntrustedInput("echo ${{ "+expr+" }}"), untrusted, expr)
+	}
+}
+
+func TestForkGuardsAndScriptInputs(t *testing.T) {
+	lint := func(job string) map[string]bool {
+		w, err := ParseWorkflow([]byte("on: pull_request_target\njobs:\n  j:\n" + job))
+		assert.NilError(t, err)
+		rules := make(map[string]bool)
+		for _, f := range w.Lint() {
+			rules[f.Rule+" "+f.Step] = true
+		}
+		return rules
+	}
+	secretJob := "    timeout-minutes: 1\n    runs-on: ubuntu-latest\n    if: %s\n    steps:\n    - run: deploy\n      env:\n        KEY: ${{ secrets.KEY }}\n"
+	for cond, guarded := range map[string]bool{
+		"github.event.pull_request.head.repo.full_name == github.repository": true,
+		"github.repository == github.event.pull_request.head.repo.full_name": true,
+		"github.event.pull_request.head.repo.fork":                           false,
+		"github.event.pull_request.head.repo.full_name != ''":                false,
+		"github.event.pull_request.head.repo.full_name != github.repository": false,
+	} {
+		rules := lint(strings.Replace(secretJob, "%s", cond, 1))
+		assert.Equal(t, !rules["fork-secrets "], guarded, cond)
+	}
+
+	rules := lint(`    timeout-minutes: 1
+    runs-on: ubuntu-latest
+    steps:
+    - name: comment
+      uses: actions/github-script@v7
+      with:
+        script: console.log("${{ github.event.pull_request.title }}")
+`)
+	assert.Assert(t, rules["script-injection comment"])
+
+	rules = lint("    uses: org/repo/.github/workflows/build.yml@main\n")
+	assert.Assert(t, rules["unpinned-action "])
+	rules = lint("    uses: org/repo/.github/workflows/build.yml@0123456789abcdef0123456789abcdef01234567\n")
+	assert.Assert(t, !rules["unpinned-action "])
+}
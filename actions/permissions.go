@@ -0,0 +1,115 @@
+package actions
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionNone  = "none"
+)
+
+// knownScopes lists scopes accepted by the `permissions` key.
+var knownScopes = map[string]bool{
+	"actions":             true,
+	"attestations":        true,
+	"checks":              true,
+	"contents":            true,
+	"deployments":         true,
+	"discussions":         true,
+	"id-token":            true,
+	"issues":              true,
+	"packages":            true,
+	"pages":               true,
+	"pull-requests":       true,
+	"repository-projects": true,
+	"security-events":     true,
+	"statuses":            true,
+}
+
+// Permissions maps GITHUB_TOKEN scopes to access levels. Nil value means
+// that permissions are inherited (from the workflow or repository
+// defaults), while empty non-nil value revokes all of them.
+type Permissions map[string]string
+
+// IsZero keeps `permissions: {}` from being dropped by omitempty.
+func (p Permissions) IsZero() bool {
+	return p == nil
+}
+
+// allPermissions grants level to every scope, as `read-all` and `write-all`
+// shorthands do. id-token can not be read, so read-all does not grant it.
+func allPermissions(level string) Permissions {
+	p := make(Permissions)
+	for scope := range knownScopes {
+		if scope == "id-token" && level == PermissionRead {
+			continue
+		}
+		p[scope] = level
+	}
+	return p
+}
+
+// UnmarshalYAML accepts both mapping and `read-all`/`write-all` shorthands.
+func (p *Permissions) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var shorthand string
+	if err := unmarshal(&shorthand); err == nil {
+		switch shorthand {
+		case "read-all":
+			*p = allPermissions(PermissionRead)
+		case "write-all":
+			*p = allPermissions(PermissionWrite)
+		default:
+			return fmt.Errorf("invalid permissions %q, expected read-all, write-all or a mapping", shorthand)
+		}
+		return nil
+	}
+	var m map[string]string
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	if m == nil {
+		m = make(map[string]string)
+	}
+	*p = Permissions(m)
+	return nil
+}
+
+// MarshalYAML emits shorthands for permissions which have them.
+func (p Permissions) MarshalYAML() (interface{}, error) {
+	for _, shorthand := range []struct{ name, level string }{{"read-all", PermissionRead}, {"write-all", PermissionWrite}} {
+		if reflect.DeepEqual(p, allPermissions(shorthand.level)) {
+			return shorthand.name, nil
+		}
+	}
+	return map[string]string(p), nil
+}
+
+// ReadOnlyPermissions is the default for generated workflows.
+func ReadOnlyPermissions() Permissions {
+	return Permissions{"contents": PermissionRead}
+}
+
+func (p Permissions) Validate() error {
+	for scope, level := range p {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown permission scope %s", scope)
+		}
+		if level != PermissionRead && level != PermissionWrite && level != PermissionNone {
+			return fmt.Errorf("invalid access level %s for scope %s", level, scope)
+		}
+		if scope == "id-token" && level == PermissionRead {
+			return fmt.Errorf("id-token scope can only be write or none")
+		}
+	}
+	return nil
+}
+
+var githubTokenRegexp = regexp.MustCompile(`\${{\s*(secrets\.GITHUB_TOKEN|github\.token)\s*}}`)
+
+func referencesGithubToken(s string) bool {
+	return githubTokenRegexp.MatchString(s)
+}
@@ -0,0 +1,165 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// Action is a third-party action referenced by generated steps.
+type Action struct {
+	Repo string
+	Tag  string
+}
+
+// Ref returns reference suitable for the `uses` field of a step.
+func (a Action) Ref() string {
+	return fmt.Sprintf("%s@%s", a.Repo, a.Tag)
+}
+
+// Actions used by generated steps. `lock` command pins those referenced by
+// workflows of the repository.
+var (
+	Checkout         = Action{Repo: "actions/checkout", Tag: "v2"}
+	SetupGo          = Action{Repo: "actions/setup-go", Tag: "v2"}
+	Cache            = Action{Repo: "actions/cache", Tag: "v2"}
+	UploadArtifact   = Action{Repo: "actions/upload-artifact", Tag: "v4"}
+	DownloadArtifact = Action{Repo: "actions/download-artifact", Tag: "v4"}
+	GolangciLint     = Action{Repo: "golangci/golangci-lint-action", Tag: "v2"}
+	RustToolchain    = Action{Repo: "actions-rs/toolchain", Tag: "v1"}
+	RustCargo        = Action{Repo: "actions-rs/cargo", Tag: "v1"}
+	RustCache        = Action{Repo: "Swatinem/rust-cache", Tag: "v1"}
+	CargoDeny        = Action{Repo: "EmbarkStudios/cargo-deny-action", Tag: "v1"}
+	Misspell         = Action{Repo: "reviewdog/action-misspell", Tag: "v1"}
+	SetupQemu        = Action{Repo: "docker/setup-qemu-action", Tag: "v3"}
+	SetupBuildx      = Action{Repo: "docker/setup-buildx-action", Tag: "v3"}
+	BuildPush        = Action{Repo: "docker/build-push-action", Tag: "v5"}
+	CosignInstaller  = Action{Repo: "sigstore/cosign-installer", Tag: "v3"}
+	Sbom             = Action{Repo: "anchore/sbom-action", Tag: "v0"}
+	AttestProvenance = Action{Repo: "actions/attest-build-provenance", Tag: "v1"}
+	KindCluster      = Action{Repo: "helm/kind-action", Tag: "v1"}
+	SetupHelm        = Action{Repo: "azure/setup-helm", Tag: "v4"}
+	PathsFilter      = Action{Repo: "dorny/paths-filter", Tag: "v3"}
+)
+
+// ParseActionRef splits `uses` reference into action and version. ok is false
+// for local and docker actions, which can not be pinned.
+func ParseActionRef(ref string) (action Action, ok bool) {
+	if strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "docker://") {
+		return Action{}, false
+	}
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) != 2 {
+		return Action{}, false
+	}
+	return Action{Repo: parts[0], Tag: parts[1]}, true
+}
+
+var shaRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// IsCommitSha checks that s is a full-length git commit hash.
+func IsCommitSha(s string) bool {
+	return shaRegexp.MatchString(s)
+}
+
+type LockedAction struct {
+	Repo string `toml:"repo"`
+	Tag  string `toml:"tag"`
+	Sha  string `toml:"sha"`
+}
+
+// Lock maps action tags to commit SHAs. It is stored in ci/actions.lock.
+type Lock struct {
+	Actions []LockedAction `toml:"action"`
+}
+
+// LoadLock reads lockfile. Missing lockfile is treated as empty.
+func LoadLock(path string) (Lock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Lock{}, nil
+	}
+	if err != nil {
+		return Lock{}, err
+	}
+	lock := Lock{}
+	if err := toml.Unmarshal(data, &lock); err != nil {
+		return Lock{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, a := range lock.Actions {
+		if !IsCommitSha(a.Sha) {
+			return Lock{}, fmt.Errorf("invalid sha %q for %s@%s in %s", a.Sha, a.Repo, a.Tag, path)
+		}
+	}
+	return lock, nil
+}
+
+func (l Lock) Serialize() ([]byte, error) {
+	sort.Slice(l.Actions, func(i, j int) bool {
+		if l.Actions[i].Repo != l.Actions[j].Repo {
+			return l.Actions[i].Repo < l.Actions[j].Repo
+		}
+		return l.Actions[i].Tag < l.Actions[j].Tag
+	})
+	return toml.Marshal(l)
+}
+
+// Lookup returns pinned commit for the action.
+func (l Lock) Lookup(a Action) (string, bool) {
+	for _, locked := range l.Actions {
+		if locked.Repo == a.Repo && locked.Tag == a.Tag {
+			return locked.Sha, true
+		}
+	}
+	return "", false
+}
+
+// Pin replaces tags in all `uses` references with locked commit SHAs. It
+// returns references which are not present in the lock.
+func (l Lock) Pin(w Workflow) (Workflow, []string) {
+	unpinnedSet := make(map[string]bool)
+	jobs := make(map[string]Job)
+	for jobName, job := range w.Jobs {
+		steps := make([]Step, 0, len(job.Steps))
+		for _, step := range job.Steps {
+			a, ok := ParseActionRef(step.Uses)
+			if ok && !IsCommitSha(a.Tag) {
+				if sha, locked := l.Lookup(a); locked {
+					step.Uses = fmt.Sprintf("%s@%s", a.Repo, sha)
+				} else {
+					unpinnedSet[step.Uses] = true
+				}
+			}
+			steps = append(steps, step)
+		}
+		job.Steps = steps
+		jobs[jobName] = job
+	}
+	w.Jobs = jobs
+	unpinned := make([]string, 0, len(unpinnedSet))
+	for ref := range unpinnedSet {
+		unpinned = append(unpinned, ref)
+	}
+	sort.Strings(unpinned)
+	return w, unpinned
+}
+
+var pinnedUsesRegexp = regexp.MustCompile(`(?m)^(\s*(?:- )?uses: )([^@\s]+)@([0-9a-f]{40})$`)
+
+// Annotate appends original tag as a comment to each pinned reference in
+// serialized workflow.
+func (l Lock) Annotate(data []byte) []byte {
+	return pinnedUsesRegexp.ReplaceAllFunc(data, func(line []byte) []byte {
+		m := pinnedUsesRegexp.FindSubmatch(line)
+		for _, locked := range l.Actions {
+			if locked.Repo == string(m[2]) && locked.Sha == string(m[3]) {
+				return []byte(fmt.Sprintf("%s # %s", line, locked.Tag))
+			}
+		}
+		return line
+	})
+}
@@ -0,0 +1,35 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	"gotest.tools/v3/assert"
+)
+
+func TestLockPinsAndAnnotates(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	lock := Lock{
+		Actions: []LockedAction{{Repo: Checkout.Repo, Tag: Checkout.Tag, Sha: sha}},
+	}
+	w := Workflow{
+		Name: "test",
+		Jobs: map[string]Job{
+			"job": {
+				Steps: []Step{
+					MakeCheckoutStep(),
+					{Uses: Cache.Ref()},
+					{Uses: "./local-action"},
+				},
+			},
+		},
+	}
+	pinned, unpinned := lock.Pin(w)
+	assert.DeepEqual(t, unpinned, []string{Cache.Ref()})
+	assert.Equal(t, pinned.Jobs["job"].Steps[0].Uses, Checkout.Repo+"@"+sha)
+
+	data, err := yaml.Marshal(pinned)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(lock.Annotate(data)), "uses: actions/checkout@"+sha+" # v2\n"))
+}
@@ -0,0 +1,152 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultRunnerImage is used for jobs which have no runner configured
+	DefaultRunnerImage = "ubuntu-22.04"
+	selfHostedLabel    = "self-hosted"
+)
+
+type hostedImage struct {
+	// deprecated images still work, but will be removed soon
+	deprecated bool
+	// retired images were removed, jobs using them never start
+	retired     bool
+	replacement string
+}
+
+// hostedImages lists GitHub-hosted runner images known to the generator.
+// GitHub adds images regularly, so other labels are only warned about.
+var hostedImages = map[string]hostedImage{
+	"ubuntu-latest":    {},
+	"ubuntu-24.04":     {},
+	"ubuntu-24.04-arm": {},
+	"ubuntu-22.04":     {},
+	"ubuntu-22.04-arm": {},
+	"ubuntu-20.04":     {retired: true, replacement: "ubuntu-22.04"},
+	"ubuntu-18.04":     {retired: true, replacement: "ubuntu-22.04"},
+	"windows-latest":   {},
+	"windows-2025":     {},
+	"windows-2022":     {},
+	"windows-11-arm":   {},
+	"windows-2019":     {retired: true, replacement: "windows-2022"},
+	"macos-latest":     {},
+	"macos-15":         {},
+	// last Intel image, GitHub announced its removal
+	"macos-15-intel": {deprecated: true, replacement: "macos-15"},
+	"macos-14":       {},
+	"macos-13":       {retired: true, replacement: "macos-15"},
+	"macos-12":       {retired: true, replacement: "macos-14"},
+	"macos-11":       {retired: true, replacement: "macos-14"},
+}
+
+// Runner selects machines the job runs on. It is either a single hosted
+// image, a set of self-hosted labels, or a runner group (optionally
+// restricted by labels).
+type Runner struct {
+	Group  string   `yaml:"group,omitempty"`
+	Labels []string `yaml:"labels,omitempty"`
+}
+
+func HostedRunner(image string) Runner {
+	return Runner{Labels: []string{image}}
+}
+
+func (r Runner) IsZero() bool {
+	return r.Group == "" && len(r.Labels) == 0
+}
+
+func (r Runner) selfHosted() bool {
+	if r.Group != "" {
+		return true
+	}
+	for _, l := range r.Labels {
+		if l == selfHostedLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that hosted runner does not use retired image. Unknown
+// images are reported by Warning, because they may be newer than the
+// generator or larger runners configured by the organization.
+func (r Runner) Validate() error {
+	if r.IsZero() {
+		return fmt.Errorf("missing runs-on")
+	}
+	if r.selfHosted() {
+		return nil
+	}
+	if len(r.Labels) == 1 && IsExpression(r.Labels[0]) {
+		// runner is chosen at runtime, e.g. from matrix
+		return nil
+	}
+	if len(r.Labels) != 1 {
+		return fmt.Errorf("hosted runner must have exactly one label, got %v (use %s label for self-hosted runners)", r.Labels, selfHostedLabel)
+	}
+	if image := hostedImages[r.Labels[0]]; image.retired {
+		return fmt.Errorf("hosted runner image %s is retired, use %s", r.Labels[0], image.replacement)
+	}
+	return nil
+}
+
+// Warning returns non-empty message if runner uses deprecated or unknown
+// hosted image.
+func (r Runner) Warning() string {
+	if r.selfHosted() || len(r.Labels) != 1 || IsExpression(r.Labels[0]) {
+		return ""
+	}
+	image, ok := hostedImages[r.Labels[0]]
+	if !ok {
+		return fmt.Sprintf("runner image %s is not known, check that it is available to the repository", r.Labels[0])
+	}
+	if !image.deprecated {
+		return ""
+	}
+	return fmt.Sprintf("runner image %s is deprecated, consider switching to %s", r.Labels[0], image.replacement)
+}
+
+func (r Runner) MarshalYAML() (interface{}, error) {
+	if r.Group != "" {
+		return struct {
+			Group  string   `yaml:"group"`
+			Labels []string `yaml:"labels,omitempty"`
+		}{r.Group, r.Labels}, nil
+	}
+	if len(r.Labels) == 1 {
+		return r.Labels[0], nil
+	}
+	return r.Labels, nil
+}
+
+// UnmarshalYAML accepts all forms of runs-on: a single label, a list of
+// labels and a mapping with group and labels.
+func (r *Runner) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var label string
+	if err := unmarshal(&label); err == nil {
+		*r = HostedRunner(label)
+		return nil
+	}
+	var labels []string
+	if err := unmarshal(&labels); err == nil {
+		*r = Runner{Labels: labels}
+		return nil
+	}
+	type plain Runner
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return fmt.Errorf("runner must be a label, a list of labels or a group: %w", err)
+	}
+	*r = Runner(p)
+	return nil
+}
+
+// IsExpression checks if s is a single `${{ }}` expression.
+func IsExpression(s string) bool {
+	return strings.HasPrefix(s, "${{") && strings.HasSuffix(s, "}}")
+}
@@ -0,0 +1,38 @@
+package actions
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	"gotest.tools/v3/assert"
+)
+
+func TestRunnerYamlRoundtrip(t *testing.T) {
+	runners := []Runner{
+		HostedRunner("ubuntu-22.04"),
+		{Labels: []string{"self-hosted", "linux"}},
+		{Group: "large", Labels: []string{"x64"}},
+	}
+	for _, r := range runners {
+		data, err := yaml.Marshal(r)
+		assert.NilError(t, err)
+		var parsed Runner
+		assert.NilError(t, yaml.Unmarshal(data, &parsed))
+		assert.DeepEqual(t, parsed, r)
+		assert.NilError(t, r.Validate())
+	}
+}
+
+func TestRunnerValidate(t *testing.T) {
+	assert.ErrorContains(t, HostedRunner("ubuntu-20.04").Validate(), "retired")
+	assert.ErrorContains(t, Runner{}.Validate(), "missing runs-on")
+	// new and larger runner images are not known in advance
+	for _, image := range []string{"ubuntu-9000", "macos-15", "ubuntu-24.04-arm", "windows-2025", "ubuntu-latest-16-cores"} {
+		assert.NilError(t, HostedRunner(image).Validate())
+	}
+	assert.Assert(t, HostedRunner("ubuntu-latest-16-cores").Warning() != "")
+	assert.Equal(t, HostedRunner("macos-15").Warning(), "")
+	// last Intel macOS image, to be removed
+	assert.Equal(t, HostedRunner("macos-15-intel").Warning(), "runner image macos-15-intel is deprecated, consider switching to macos-15")
+	assert.Equal(t, HostedRunner(DefaultRunnerImage).Warning(), "")
+}
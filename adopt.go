@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/bors"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"gopkg.in/yaml.v3"
+)
+
+// jobEditOverride returns override which turns generated job into the edited
+// one. Changes which can not be expressed are detected by the caller.
+func jobEditOverride(generated, edited actions.Job) (config.JobOverride, error) {
+	o := config.JobOverride{}
+	if edited.Timeout != generated.Timeout {
+		o.Timeout = edited.Timeout
+	}
+	if !reflect.DeepEqual(edited.RunsOn, generated.RunsOn) {
+		o.RunsOn = edited.RunsOn
+	}
+	if edited.If != generated.If {
+		o.If = edited.If
+	}
+	for k, v := range edited.Env {
+		if prev, ok := generated.Env[k]; !ok || prev != v {
+			if o.Env == nil {
+				o.Env = make(map[string]string)
+			}
+			o.Env[k] = v
+		}
+	}
+	pending := make([]actions.Step, 0)
+	next := 0
+	for _, step := range edited.Steps {
+		if next < len(generated.Steps) && reflect.DeepEqual(step, generated.Steps[next]) {
+			if len(pending) != 0 {
+				anchor := generated.Steps[next].Name
+				if anchor == "" {
+					return config.JobOverride{}, fmt.Errorf("steps inserted before unnamed step")
+				}
+				o.InsertSteps = append(o.InsertSteps, config.StepInsertion{Before: anchor, Steps: pending})
+				pending = make([]actions.Step, 0)
+			}
+			next++
+			continue
+		}
+		pending = append(pending, step)
+	}
+	if len(pending) != 0 {
+		if len(generated.Steps) == 0 || generated.Steps[len(generated.Steps)-1].Name == "" {
+			return config.JobOverride{}, fmt.Errorf("steps appended after unnamed step")
+		}
+		o.InsertSteps = append(o.InsertSteps, config.StepInsertion{
+			After: generated.Steps[len(generated.Steps)-1].Name,
+			Steps: pending,
+		})
+	}
+	return o, nil
+}
+
+// workflowEditOverrides converts hand edits of a workflow into job
+// overrides. The result is checked by applying overrides to the generated
+// workflow, so edits which can not be expressed are reported as errors.
+func workflowEditOverrides(generated, edited actions.Workflow) (map[string]config.JobOverride, error) {
+	if !reflect.DeepEqual(generated.On, edited.On) ||
+		!reflect.DeepEqual(generated.Permissions, edited.Permissions) ||
+		!reflect.DeepEqual(generated.Env, edited.Env) {
+		return nil, fmt.Errorf("workflow-level settings were changed")
+	}
+	for jobName := range edited.Jobs {
+		if _, ok := generated.Jobs[jobName]; !ok {
+			return nil, fmt.Errorf("job %s was added", jobName)
+		}
+	}
+	overrides := make(map[string]config.JobOverride)
+	for jobName, job := range generated.Jobs {
+		editedJob, ok := edited.Jobs[jobName]
+		if !ok {
+			overrides[jobName] = config.JobOverride{Disabled: true}
+			continue
+		}
+		if reflect.DeepEqual(job, editedJob) {
+			continue
+		}
+		o, err := jobEditOverride(job, editedJob)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jobName, err)
+		}
+		overrides[jobName] = o
+	}
+	patched, err := newOverrideSet(overrides).apply(generated, &bors.BorsConfig{})
+	if err != nil {
+		return nil, err
+	}
+	for jobName, job := range patched.Jobs {
+		// provenance is not serialized, so edited job never has it
+		job.Provenance = nil
+		if !reflect.DeepEqual(job, edited.Jobs[jobName]) {
+			return nil, fmt.Errorf("changes to job %s can not be expressed as override", jobName)
+		}
+	}
+	return overrides, nil
+}
+
+// mergeOverride adds adopted changes to an override from config.
+func mergeOverride(o, adopted config.JobOverride) config.JobOverride {
+	o.Disabled = o.Disabled || adopted.Disabled
+	if adopted.Timeout != 0 {
+		o.Timeout = adopted.Timeout
+	}
+	if !adopted.RunsOn.IsZero() {
+		o.RunsOn = adopted.RunsOn
+	}
+	if adopted.If != "" {
+		o.If = adopted.If
+	}
+	for k, v := range adopted.Env {
+		if o.Env == nil {
+			o.Env = make(map[string]string)
+		}
+		o.Env[k] = v
+	}
+	// adopted insertions reference steps after existing insertions are
+	// applied, so they go last
+	o.InsertSteps = append(o.InsertSteps, adopted.InsertSteps...)
+	return o
+}
+
+// configSection returns mapping stored under key in the config document,
+// creating it if needed.
+func configSection(root *yaml.Node, key string) *yaml.Node {
+	section := actions.MappingValue(root, key)
+	if section == nil || section.Kind != yaml.MappingNode {
+		section = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, section)
+	}
+	return section
+}
+
+// setMappingValue replaces value stored under key, or appends it.
+func setMappingValue(m *yaml.Node, key string, value interface{}) error {
+	encoded := yaml.Node{}
+	if err := encoded.Encode(value); err != nil {
+		return err
+	}
+	if node := actions.MappingValue(m, key); node != nil {
+		*node = encoded
+	} else {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &encoded)
+	}
+	return nil
+}
+
+// mergeIntoConfig merges overrides and custom jobs into the config, keeping
+// the rest of it (including comments) intact. Custom jobs replace existing
+// ones with the same name.
+func mergeIntoConfig(data []byte, overrides map[string]config.JobOverride, customJobs map[string]actions.Job) ([]byte, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", config.ConfigPath, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if len(overrides) != 0 {
+		section := configSection(root, "overrides")
+		jobNames := make([]string, 0, len(overrides))
+		for jobName := range overrides {
+			jobNames = append(jobNames, jobName)
+		}
+		sort.Strings(jobNames)
+		for _, jobName := range jobNames {
+			existing := config.JobOverride{}
+			if node := actions.MappingValue(section, jobName); node != nil {
+				if err := node.Decode(&existing); err != nil {
+					return nil, fmt.Errorf("failed to parse override for %s: %w", jobName, err)
+				}
+			}
+			if err := setMappingValue(section, jobName, mergeOverride(existing, overrides[jobName])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(customJobs) != 0 {
+		section := configSection(root, "customJobs")
+		jobNames := make([]string, 0, len(customJobs))
+		for jobName := range customJobs {
+			jobNames = append(jobNames, jobName)
+		}
+		sort.Strings(jobNames)
+		for _, jobName := range jobNames {
+			if err := setMappingValue(section, jobName, customJobs[jobName]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addOverridesToConfig returns the config file with overrides merged in.
+func addOverridesToConfig(repoRoot string, overrides map[string]config.JobOverride) ([]byte, error) {
+	data, err := os.ReadFile(path.Join(repoRoot, config.ConfigPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return mergeIntoConfig(data, overrides, nil)
+}
+
+// adoptEdits converts hand edits of workflows into overrides in config. It
+// returns names of files whose edits were adopted and the updated config,
+// which is nil if nothing was adopted.
+func adoptEdits(repoRoot string, edits []handEdit) ([]string, []byte, error) {
+	overrides := make(map[string]config.JobOverride)
+	adopted := make([]string, 0)
+	for _, e := range edits {
+		if !strings.HasPrefix(e.name, ".github/workflows/") {
+			continue
+		}
+		generated, err := actions.ParseWorkflow(e.generated)
+		if err != nil {
+			return nil, nil, err
+		}
+		edited, err := actions.ParseWorkflow(e.current)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", e.name, err)
+		}
+		fileOverrides, err := workflowEditOverrides(generated, edited)
+		if err != nil {
+			log.Printf("can not adopt edits of %s: %v", e.name, err)
+			continue
+		}
+		for jobName, o := range fileOverrides {
+			overrides[jobName] = o
+		}
+		adopted = append(adopted, e.name)
+	}
+	if len(overrides) == 0 {
+		return adopted, nil, nil
+	}
+	data, err := addOverridesToConfig(repoRoot, overrides)
+	return adopted, data, err
+}
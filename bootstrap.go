@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/fileset"
+	"github.com/jjs-dev/ci-config-gen/languages"
+)
+
+// repoInspection contains facts about repository used to guess config.
+type repoInspection struct {
+	languages []string
+	e2e       bool
+	codegen   bool
+	// dockerfiles are paths of Dockerfiles relative to the repository root
+	dockerfiles []string
+	// workflows are hand-written workflows in .github/workflows
+	workflows []string
+}
+
+// skippedDirs are never searched for Dockerfiles.
+var skippedDirs = map[string]bool{
+	".git":         true,
+	"target":       true,
+	"node_modules": true,
+	"vendor":       true,
+}
+
+func findDockerfiles(root string) ([]string, error) {
+	found := make([]string, 0)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && skippedDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "Dockerfile" {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			found = append(found, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(found)
+	return found, err
+}
+
+// findHandWrittenWorkflows returns workflows which were not produced by the
+// generator.
+func findHandWrittenWorkflows(root string) ([]string, error) {
+	files, err := findWorkflowFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(string(data), generatedHeader) {
+			rel, err := filepath.Rel(root, f)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, filepath.ToSlash(rel))
+		}
+	}
+	return res, nil
+}
+
+func inspectRepo(root string) (repoInspection, error) {
+	res := repoInspection{}
+	for _, lang := range languages.MakeLanguages() {
+		if lang.Used(root) {
+			res.languages = append(res.languages, lang.Name())
+		}
+	}
+	res.e2e = fileExists(path.Join(root, "ci/e2e-build.sh"))
+	res.codegen = fileExists(path.Join(root, "ci/codegen.sh"))
+	var err error
+	if res.dockerfiles, err = findDockerfiles(root); err != nil {
+		return repoInspection{}, err
+	}
+	if res.workflows, err = findHandWrittenWorkflows(root); err != nil {
+		return repoInspection{}, err
+	}
+	return res, nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// guessBuildTimeout returns timeout in minutes which should be enough for
+// cold builds.
+func (r repoInspection) guessBuildTimeout() int {
+	timeout := 10
+	for _, lang := range r.languages {
+		switch lang {
+		case "rust", "cpp":
+			timeout += 20
+		default:
+			timeout += 5
+		}
+	}
+	if r.e2e {
+		timeout += 15
+	}
+	return timeout
+}
+
+// imageNames derives image names from directories containing Dockerfiles.
+// Names of images whose directories have the same name are qualified with
+// parent directories, e.g. a-api and b-api.
+func imageNames(root string, dockerfiles []string) []string {
+	// directory components of every Dockerfile, innermost last
+	dirs := make([][]string, len(dockerfiles))
+	depths := make([]int, len(dockerfiles))
+	for i, df := range dockerfiles {
+		dir := path.Dir(df)
+		if dir == "." {
+			dir = "app"
+			if abs, err := filepath.Abs(root); err == nil {
+				dir = filepath.Base(abs)
+			}
+		}
+		dirs[i] = strings.Split(strings.ToLower(dir), "/")
+		depths[i] = 1
+	}
+	name := func(i int) string {
+		return strings.Join(dirs[i][len(dirs[i])-depths[i]:], "-")
+	}
+	for {
+		byName := make(map[string][]int)
+		for i := range dockerfiles {
+			byName[name(i)] = append(byName[name(i)], i)
+		}
+		qualified := false
+		for _, clash := range byName {
+			if len(clash) < 2 {
+				continue
+			}
+			for _, i := range clash {
+				if depths[i] < len(dirs[i]) {
+					depths[i]++
+					qualified = true
+				}
+			}
+		}
+		if !qualified {
+			break
+		}
+	}
+	res := make([]string, len(dockerfiles))
+	taken := make(map[string]bool)
+	for i := range dockerfiles {
+		// clash remains if one directory is a suffix of another one
+		res[i] = name(i)
+		for n := 2; taken[res[i]]; n++ {
+			res[i] = fmt.Sprintf("%s-%d", name(i), n)
+		}
+		taken[res[i]] = true
+	}
+	return res
+}
+
+// renderStarterConfig produces commented config for the repository.
+func renderStarterConfig(root string, r repoInspection) string {
+	var sb strings.Builder
+	sb.WriteString("# Configuration of ci-config-gen, created by `ci-config-gen init`.\n")
+	if len(r.languages) != 0 {
+		fmt.Fprintf(&sb, "# Detected languages: %s.\n", strings.Join(r.languages, ", "))
+	}
+	sb.WriteString("\n# Jobs are cancelled if they take longer than this.\n")
+	fmt.Fprintf(&sb, "buildTimeoutMinutes: %d\n", r.guessBuildTimeout())
+
+	sb.WriteString("\n")
+	if r.e2e {
+		sb.WriteString("# ci/e2e-build.sh found: e2e tests run ci/e2e-run.sh on its artifacts.\nnoE2e: false\n")
+	} else {
+		sb.WriteString("# ci/e2e-build.sh not found, so e2e tests are disabled.\nnoE2e: true\n")
+	}
+	if r.codegen {
+		sb.WriteString("\n# ci/codegen.sh found: CI checks that generated code is up to date.\ncodegen: true\n")
+	}
+
+	sb.WriteString("\n")
+	if len(r.dockerfiles) == 0 {
+		sb.WriteString("# No Dockerfiles found, so nothing is published.\nnoPublish: true\n")
+	} else {
+		sb.WriteString("# Images are built from found Dockerfiles and pushed to ghcr.io.\ndockerImages:\n")
+		names := imageNames(root, r.dockerfiles)
+		for i, df := range r.dockerfiles {
+			fmt.Fprintf(&sb, "  - name: %s\n    context: %s\n", names[i], path.Dir(df))
+		}
+	}
+
+	if len(r.workflows) != 0 {
+		sb.WriteString("\n# Hand-written workflows were found, they are left untouched:\n")
+		for _, w := range r.workflows {
+			fmt.Fprintf(&sb, "#   %s\n", w)
+		}
+	}
+	return sb.String()
+}
+
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository")
+	force := flags.Bool("force", false, "overwrite existing "+config.ConfigPath)
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+	if fileExists(path.Join(*repoRoot, config.ConfigPath)) && !*force {
+		log.Fatalf("%s already exists, use --force to overwrite it", config.ConfigPath)
+	}
+	inspection, err := inspectRepo(*repoRoot)
+	if err != nil {
+		log.Fatalf("failed to inspect repository: %v", err)
+	}
+	files := fileset.New()
+	if err := files.Add(config.ConfigPath, []byte(renderStarterConfig(*repoRoot, inspection))); err != nil {
+		log.Fatal(err)
+	}
+	if err := files.Write(*repoRoot); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("created %s, run `ci-config-gen --repo-root %s` to generate workflows", config.ConfigPath, *repoRoot)
+}
@@ -1,11 +1,37 @@
 package bors
 
-import "github.com/pelletier/go-toml"
+import (
+	"fmt"
 
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/pelletier/go-toml"
+)
+
+// BorsConfig models bors.toml as documented by bors-ng.
 type BorsConfig struct {
 	DeleteMergedBranches bool     `toml:"delete-merged-branches"`
 	Timeout              int      `toml:"timeout-sec"`
 	Status               []string `toml:"status"`
+	// PrStatus lists checks which must pass on pull request before it can
+	// be merged
+	PrStatus             []string   `toml:"pr_status,omitempty"`
+	BlockLabels          []string   `toml:"block_labels,omitempty"`
+	RequiredApprovals    *int       `toml:"required_approvals,omitempty"`
+	UseSquashMerge       bool       `toml:"use_squash_merge,omitempty"`
+	CutBodyAfter         string     `toml:"cut_body_after,omitempty"`
+	UpdateBaseForDeletes bool       `toml:"update_base_for_deletes,omitempty"`
+	UseCodeowners        bool       `toml:"use_codeowners,omitempty"`
+	Committer            *Committer `toml:"committer,omitempty"`
+
+	// jobs are keys of jobs required to pass, Status is computed from them
+	// by Resolve
+	jobs []string
+}
+
+// Committer overrides author of merge commits created by bors.
+type Committer struct {
+	Name  string `toml:"name"`
+	Email string `toml:"email"`
 }
 
 func (b *BorsConfig) ApplyDefaults() {
@@ -17,5 +43,114 @@ func (b *BorsConfig) Serialize() ([]byte, error) {
 }
 
 func (b *BorsConfig) AddJob(jobName string) {
-	b.Status = append(b.Status, jobName)
+	b.jobs = append(b.jobs, jobName)
+}
+
+func (b *BorsConfig) RemoveJob(jobName string) {
+	jobs := make([]string, 0, len(b.jobs))
+	for _, j := range b.jobs {
+		if j != jobName {
+			jobs = append(jobs, j)
+		}
+	}
+	b.jobs = jobs
+}
+
+func (b *BorsConfig) Jobs() []string {
+	return b.jobs
+}
+
+// Resolve fills status list with check names of added jobs and validates
+// the result.
+func (b *BorsConfig) Resolve(workflows []actions.Workflow) error {
+	status, err := actions.ResolveCheckNames(workflows, b.jobs)
+	if err != nil {
+		return err
+	}
+	b.Status = status
+	return b.Validate(workflows)
+}
+
+// checkTriggers maps names of checks produced by the workflows to triggers of
+// the workflows producing them.
+func checkTriggers(workflows []actions.Workflow) map[string][]actions.Trigger {
+	checks := make(map[string][]actions.Trigger)
+	for _, w := range workflows {
+		for jobName, job := range w.Jobs {
+			names, err := job.CheckNames(jobName)
+			if err != nil {
+				continue
+			}
+			for _, name := range names {
+				checks[name] = append(checks[name], w.On)
+			}
+		}
+	}
+	return checks
+}
+
+func pushesTo(t actions.Trigger, branch string) bool {
+	if t.Push == nil {
+		return false
+	}
+	for _, b := range t.Push.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that every status is reported by a job which runs on
+// pushes to bors branches, and every PR status is reported by a job which
+// runs on pull requests.
+func (b *BorsConfig) Validate(workflows []actions.Workflow) error {
+	checks := checkTriggers(workflows)
+	for _, status := range b.Status {
+		triggers, ok := checks[status]
+		if !ok {
+			return fmt.Errorf("status %s is not reported by any job", status)
+		}
+		for _, branch := range b.Branches() {
+			found := false
+			for _, t := range triggers {
+				found = found || pushesTo(t, branch)
+			}
+			if !found {
+				return fmt.Errorf("status %s is not reported on pushes to %s", status, branch)
+			}
+		}
+	}
+	for _, status := range b.PrStatus {
+		found := false
+		for _, t := range checks[status] {
+			found = found || t.PullRequest != nil
+		}
+		if !found {
+			return fmt.Errorf("pr_status %s is not reported by any job running on pull requests", status)
+		}
+	}
+	if b.RequiredApprovals != nil && *b.RequiredApprovals < 0 {
+		return fmt.Errorf("required_approvals must not be negative")
+	}
+	if b.Committer != nil && (b.Committer.Name == "" || b.Committer.Email == "") {
+		return fmt.Errorf("committer must have name and email")
+	}
+	return nil
+}
+
+// Branches returns branches bors pushes merge candidates to.
+func (b *BorsConfig) Branches() []string {
+	return []string{"staging", "trying"}
+}
+
+// PatchTrigger does nothing: bors candidates are tested on pushes.
+func (b *BorsConfig) PatchTrigger(t *actions.Trigger) {}
+
+func (b *BorsConfig) Files() (map[string][]byte, error) {
+	data, err := b.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"bors.toml": data}, nil
 }
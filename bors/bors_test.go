@@ -0,0 +1,52 @@
+package bors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"gotest.tools/v3/assert"
+)
+
+func ciWorkflow() actions.Workflow {
+	return actions.Workflow{
+		On: actions.Trigger{
+			PullRequest: &actions.PullRequestTrigger{},
+			Push:        &actions.PushTrigger{Branches: []string{"staging", "trying"}},
+		},
+		Jobs: map[string]actions.Job{
+			"test": {},
+			"lint": {Name: "Lint sources"},
+		},
+	}
+}
+
+func TestValidateStatus(t *testing.T) {
+	bc := BorsConfig{Status: []string{"test", "Lint sources"}, PrStatus: []string{"test"}}
+	assert.NilError(t, bc.Validate([]actions.Workflow{ciWorkflow()}))
+
+	bc.Status = append(bc.Status, "lint")
+	assert.ErrorContains(t, bc.Validate([]actions.Workflow{ciWorkflow()}), "status lint is not reported")
+
+	w := ciWorkflow()
+	w.On.Push.Branches = []string{"staging"}
+	bc = BorsConfig{Status: []string{"test"}}
+	assert.ErrorContains(t, bc.Validate([]actions.Workflow{w}), "pushes to trying")
+}
+
+func TestSerializeOptionalFields(t *testing.T) {
+	approvals := 2
+	bc := BorsConfig{
+		Status:            []string{"test"},
+		RequiredApprovals: &approvals,
+		BlockLabels:       []string{"do-not-merge"},
+		Committer:         &Committer{Name: "bors", Email: "bors@example.com"},
+	}
+	data, err := bc.Serialize()
+	assert.NilError(t, err)
+	s := string(data)
+	assert.Assert(t, strings.Contains(s, "required_approvals = 2"), s)
+	assert.Assert(t, strings.Contains(s, `block_labels = ["do-not-merge"]`), s)
+	assert.Assert(t, strings.Contains(s, "[committer]"), s)
+	assert.Assert(t, !strings.Contains(s, "use_squash_merge"), s)
+}
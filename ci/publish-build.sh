@@ -1,3 +0,0 @@
-set -euxo pipefail
-
-docker build -t ci-config-gen .
\ No newline at end of file
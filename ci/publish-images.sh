@@ -1,19 +1,33 @@
+# GENERATED FILE DO NOT EDIT sha256:a2179ec104b6eb108688ce16061aa47e2d5ecde31a215bf707c4f6170dad8450
 set -euxo pipefail
 
-# GENERATED FILE DO NOT EDIT
-if [ "$GITHUB_REF" = "refs/heads/master" ]
-then
-  TAG="latest"
-elif [ "$GITHUB_REF" = "refs/heads/trying" ]
-then
-  TAG="dev"
-elif [ "$GITHUB_REF" = "refs/heads/staging" ]
-then
+TAGS=""
+case "$GITHUB_REF" in
+refs/heads/master)
+  TAGS="latest"
+  ;;
+refs/heads/trying)
+  TAGS="dev"
+  ;;
+refs/tags/*)
   exit 0
-else
+  ;;
+refs/heads/staging)
+  exit 0
+  ;;
+*)
   echo "unknown GITHUB_REF: $GITHUB_REF"
   exit 1
-fi
-echo $GITHUB_TOKEN | docker login ghcr.io -u $GITHUB_ACTOR --password-stdin
-docker tag ci-config-gen ghcr.io/jjs-dev/ci-config-gen:$TAG
-docker push ghcr.io/jjs-dev/ci-config-gen:$TAG
\ No newline at end of file
+  ;;
+esac
+echo "$REGISTRY_GHCR_PASSWORD" | docker login ghcr.io -u "$REGISTRY_GHCR_USERNAME" --password-stdin
+echo "push=true" >> "$GITHUB_OUTPUT"
+echo "created=$(date -u +%Y-%m-%dT%H:%M:%SZ)" >> "$GITHUB_OUTPUT"
+{
+echo "ci_config_gen_tags<<EOF"
+for TAG in $TAGS
+do
+  echo "ghcr.io/jjs-dev/ci-config-gen:$TAG"
+done
+echo "EOF"
+} >> "$GITHUB_OUTPUT"
@@ -7,47 +7,232 @@ import (
 	"os"
 	"path"
 
+	"github.com/jjs-dev/ci-config-gen/actions"
 	"gopkg.in/yaml.v2"
 )
 
+// ConfigPath is location of the generator config, relative to the repository
+// root
+const ConfigPath = "ci/config.yaml"
+
+// ActionsLockPath is location of the lockfile with pinned actions, relative
+// to the repository root
+const ActionsLockPath = "ci/actions.lock"
+
 type CiConfig struct {
-	NoPublish                bool     `yaml:"noPublish"`
-	NoE2e                    bool     `yaml:"noE2e"`
-	Codegen                  bool     `yaml:"codegen"`
-	DockerImages             []string `yaml:"dockerImages"`
-	BuildTimeout             int      `yaml:"buildTimeoutMinutes"`
-	JobTimeout               int      `yaml:"jobTimeoutMinutes"`
-	InternalHackForGenerator bool     `yaml:"internalHackForGenerator"`
+	NoPublish    bool                `yaml:"noPublish"`
+	NoE2e        bool                `yaml:"noE2e"`
+	E2e          E2eConfig           `yaml:"e2e"`
+	Codegen      bool                `yaml:"codegen"`
+	DockerImages []DockerImage       `yaml:"dockerImages"`
+	Registries   map[string]Registry `yaml:"registries"`
+	Tagging      TaggingPolicy       `yaml:"tagging"`
+	Release      ReleaseConfig       `yaml:"release"`
+	Packages     PackagesConfig      `yaml:"packages"`
+	// AggregateChecks adds ci-success job, which is the only required check
+	// of the ci workflow
+	AggregateChecks bool `yaml:"aggregateChecks"`
+	// PathFilters skips language and e2e jobs when no relevant files changed.
+	// Merge candidates are always fully tested.
+	PathFilters bool `yaml:"pathFilters"`
+	// MergeGating selects how merges are gated: bors (default) or mergeQueue
+	MergeGating              string           `yaml:"mergeGating"`
+	Bors                     BorsSettings     `yaml:"bors"`
+	MergeQueue               MergeQueueConfig `yaml:"mergeQueue"`
+	BuildTimeout             int              `yaml:"buildTimeoutMinutes"`
+	JobTimeout               int              `yaml:"jobTimeoutMinutes"`
+	InternalHackForGenerator bool             `yaml:"internalHackForGenerator"`
+	Runners                  RunnerConfig     `yaml:"runners"`
+	// RequirePinnedActions makes generation fail if some action is missing
+	// from the lockfile
+	RequirePinnedActions bool `yaml:"requirePinnedActions"`
+	// Overrides patches generated jobs, keyed by job name
+	Overrides map[string]JobOverride `yaml:"overrides"`
+	// CustomJobs are added to the ci workflow as is, keyed by job name
+	CustomJobs map[string]actions.Job `yaml:"customJobs"`
+}
+
+// BorsSettings are copied to the generated bors.toml. Status list is computed
+// by the generator.
+type BorsSettings struct {
+	// PrStatus lists jobs which must pass on a pull request before it can be
+	// merged
+	PrStatus             []string `yaml:"prStatus"`
+	BlockLabels          []string `yaml:"blockLabels"`
+	RequiredApprovals    *int     `yaml:"requiredApprovals"`
+	UseSquashMerge       bool     `yaml:"useSquashMerge"`
+	CutBodyAfter         string   `yaml:"cutBodyAfter"`
+	UpdateBaseForDeletes bool     `yaml:"updateBaseForDeletes"`
+	UseCodeowners        bool     `yaml:"useCodeowners"`
+	Committer            *struct {
+		Name  string `yaml:"name"`
+		Email string `yaml:"email"`
+	} `yaml:"committer"`
+}
+
+// PackagesConfig enables publishing of language packages on `v*` tags.
+type PackagesConfig struct {
+	// Crates publishes workspace crates to crates.io
+	Crates            bool   `yaml:"crates"`
+	CratesTokenSecret string `yaml:"cratesTokenSecret"`
+	// GoModuleTags verifies that tags are valid Go module versions
+	GoModuleTags bool `yaml:"goModuleTags"`
+}
+
+func (p PackagesConfig) Enabled() bool {
+	return p.Crates || p.GoModuleTags
+}
+
+// RunnerConfig selects runners for generated jobs. Job-specific runner takes
+// precedence over language-specific one, which takes precedence over default.
+type RunnerConfig struct {
+	Default   actions.Runner            `yaml:"default"`
+	Languages map[string]actions.Runner `yaml:"languages"`
+	Jobs      map[string]actions.Runner `yaml:"jobs"`
+}
+
+// Resolve returns runner for the job. lang is name of the language which
+// generated the job, or empty string for language-independent jobs.
+func (c RunnerConfig) Resolve(lang string, jobName string) actions.Runner {
+	if r, ok := c.Jobs[jobName]; ok {
+		return r
+	}
+	if r, ok := c.Languages[lang]; ok {
+		return r
+	}
+	if !c.Default.IsZero() {
+		return c.Default
+	}
+	return actions.HostedRunner(actions.DefaultRunnerImage)
+}
+
+func (c RunnerConfig) validate() error {
+	runners := map[string]actions.Runner{
+		"default": c.Resolve("", ""),
+	}
+	for lang, r := range c.Languages {
+		runners["language "+lang] = r
+	}
+	for jobName, r := range c.Jobs {
+		runners["job "+jobName] = r
+	}
+	for what, r := range runners {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("invalid %s runner: %w", what, err)
+		}
+		if warn := r.Warning(); warn != "" {
+			log.Printf("warning: %s runner: %s", what, warn)
+		}
+	}
+	return nil
+}
+
+// JobOverride describes modifications of a single generated job.
+type JobOverride struct {
+	Disabled bool              `yaml:"disabled,omitempty"`
+	Timeout  int               `yaml:"timeoutMinutes,omitempty"`
+	RunsOn   actions.Runner    `yaml:"runsOn,omitempty"`
+	If       string            `yaml:"if,omitempty"`
+	Env      map[string]string `yaml:"env,omitempty"`
+	// InsertSteps adds steps before or after existing steps, which are
+	// referenced by name
+	InsertSteps []StepInsertion `yaml:"insertSteps,omitempty"`
+}
+
+type StepInsertion struct {
+	Before string         `yaml:"before,omitempty"`
+	After  string         `yaml:"after,omitempty"`
+	Steps  []actions.Step `yaml:"steps"`
+}
+
+func (s StepInsertion) Validate() error {
+	if (s.Before == "") == (s.After == "") {
+		return fmt.Errorf("exactly one of before and after must be specified")
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("no steps to insert")
+	}
+	return nil
 }
 
 func Load(root string) (CiConfig, error) {
-	configPath := path.Join(root, "ci/config.yaml")
+	configPath := path.Join(root, ConfigPath)
 	_, err := os.Stat(configPath)
 	if errors.Is(err, os.ErrNotExist) {
-		log.Fatalf("config not exists at %s", configPath)
+		return CiConfig{}, fmt.Errorf("config not found at %s, run `ci-config-gen init` to create it", configPath)
 	}
 	configData, err := os.ReadFile(configPath)
 	if err != nil {
 		return CiConfig{}, err
 	}
-	config := CiConfig{}
-	err = yaml.Unmarshal(configData, &config)
+	return Parse(configData)
+}
 
-	if err != nil {
+// Parse decodes and validates config, filling in defaults.
+func Parse(data []byte) (CiConfig, error) {
+	config := CiConfig{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
 		return CiConfig{}, err
 	}
 
 	if !config.NoPublish {
-		if len(config.DockerImages) == 0 {
-			return CiConfig{}, fmt.Errorf("publish enabled, but no images listed")
+		if len(config.DockerImages) == 0 && !config.Packages.Enabled() {
+			return CiConfig{}, fmt.Errorf("publish enabled, but no images or packages listed")
 		}
 	}
+	if config.Packages.CratesTokenSecret == "" {
+		config.Packages.CratesTokenSecret = "CARGO_REGISTRY_TOKEN"
+	}
+	if err := config.normalizeImages(); err != nil {
+		return CiConfig{}, err
+	}
+	if err := config.E2e.normalize(); err != nil {
+		return CiConfig{}, err
+	}
+	if config.E2e.Kind != nil && len(config.DockerImages) == 0 {
+		return CiConfig{}, fmt.Errorf("e2e.kind requires dockerImages to load into the cluster")
+	}
+	if err := config.Release.normalize(); err != nil {
+		return CiConfig{}, fmt.Errorf("invalid release config: %w", err)
+	}
+	if err := config.normalizeGating(); err != nil {
+		return CiConfig{}, err
+	}
+	// default tagged branches depend on gating
+	if err := config.Tagging.normalize(config.defaultTaggingPolicy()); err != nil {
+		return CiConfig{}, fmt.Errorf("invalid tagging policy: %w", err)
+	}
 	if config.BuildTimeout == 0 {
 		return CiConfig{}, fmt.Errorf("build timeout not specified")
 	}
 	if config.JobTimeout == 0 {
 		config.JobTimeout = config.BuildTimeout
 	}
+	if err := config.Runners.validate(); err != nil {
+		return CiConfig{}, err
+	}
+	for jobName, override := range config.Overrides {
+		if !override.RunsOn.IsZero() {
+			if err := override.RunsOn.Validate(); err != nil {
+				return CiConfig{}, fmt.Errorf("invalid override for job %s: %w", jobName, err)
+			}
+		}
+		for _, ins := range override.InsertSteps {
+			if err := ins.Validate(); err != nil {
+				return CiConfig{}, fmt.Errorf("invalid override for job %s: %w", jobName, err)
+			}
+		}
+	}
+	for jobName, job := range config.CustomJobs {
+		if len(job.Steps) == 0 && job.Uses == "" {
+			return CiConfig{}, fmt.Errorf("custom job %s has no steps", jobName)
+		}
+		if !job.RunsOn.IsZero() {
+			if err := job.RunsOn.Validate(); err != nil {
+				return CiConfig{}, fmt.Errorf("invalid custom job %s: %w", jobName, err)
+			}
+		}
+	}
 
 	return config, nil
 }
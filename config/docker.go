@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is used for images which do not list registries
+	// explicitly.
+	DefaultRegistry = "ghcr"
+	// DefaultPlatform is used for generator-built images which do not list
+	// platforms explicitly.
+	DefaultPlatform = "linux/amd64"
+)
+
+var platformRegexp = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+(/[a-z0-9]+)?$`)
+
+// Registry describes a container registry images are pushed to.
+type Registry struct {
+	Host string `yaml:"host"`
+	// Namespace is prepended to image names which do not specify
+	// repository explicitly
+	Namespace string `yaml:"namespace"`
+	// Username is used as is; it may be an expression such as
+	// `${{ github.actor }}`. UsernameSecret takes precedence.
+	Username       string `yaml:"username"`
+	UsernameSecret string `yaml:"usernameSecret"`
+	PasswordSecret string `yaml:"passwordSecret"`
+}
+
+func defaultRegistries() map[string]Registry {
+	return map[string]Registry{
+		DefaultRegistry: {
+			Host:           "ghcr.io",
+			Namespace:      "jjs-dev",
+			Username:       "${{ github.actor }}",
+			PasswordSecret: "GITHUB_TOKEN",
+		},
+	}
+}
+
+func (r Registry) validate() error {
+	if r.Host == "" {
+		return fmt.Errorf("host not specified")
+	}
+	if r.Username == "" && r.UsernameSecret == "" {
+		return fmt.Errorf("one of username and usernameSecret must be specified")
+	}
+	if r.PasswordSecret == "" {
+		return fmt.Errorf("passwordSecret not specified")
+	}
+	return nil
+}
+
+// UsernameExpr returns expression evaluating to the registry username.
+func (r Registry) UsernameExpr() string {
+	if r.UsernameSecret != "" {
+		return fmt.Sprintf("${{ secrets.%s }}", r.UsernameSecret)
+	}
+	return r.Username
+}
+
+func (r Registry) PasswordExpr() string {
+	return fmt.Sprintf("${{ secrets.%s }}", r.PasswordSecret)
+}
+
+// ImageRef returns full reference (without tag) of the image in the registry.
+func (r Registry) ImageRef(img DockerImage) string {
+	repo := img.Repository
+	if repo == "" {
+		repo = img.Name
+		if r.Namespace != "" {
+			repo = r.Namespace + "/" + repo
+		}
+	}
+	return fmt.Sprintf("%s/%s", r.Host, repo)
+}
+
+// RegistryEnvPrefix returns prefix of environment variables holding
+// credentials for the registry.
+func RegistryEnvPrefix(id string) string {
+	return "REGISTRY_" + strings.ToUpper(identifier(id))
+}
+
+// identifier replaces characters which are not allowed in environment
+// variable names.
+func identifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// DockerImage is an image published by the publish workflow.
+type DockerImage struct {
+	// Name is the name of locally built image
+	Name string `yaml:"name"`
+	// Repository overrides path of the image in registries
+	Repository string `yaml:"repository"`
+	// Registries lists ids of registries the image is pushed to
+	Registries []string `yaml:"registries"`
+	// Context makes generator build the image itself with docker buildx.
+	// Images without context are built by ci/publish-build.sh.
+	Context string `yaml:"context"`
+	// Dockerfile defaults to Dockerfile in the context directory
+	Dockerfile string            `yaml:"dockerfile"`
+	Platforms  []string          `yaml:"platforms"`
+	BuildArgs  map[string]string `yaml:"buildArgs"`
+	// Sbom generates SBOM with syft and attests it with cosign
+	Sbom bool `yaml:"sbom"`
+	// Sign signs pushed image with cosign keyless signing
+	Sign bool `yaml:"sign"`
+	// Provenance attests build provenance of pushed image
+	Provenance bool `yaml:"provenance"`
+}
+
+// BuiltByGenerator checks if image is built by the generated workflow.
+func (i DockerImage) BuiltByGenerator() bool {
+	return i.Context != ""
+}
+
+// DockerfilePath returns path to the Dockerfile of generator-built image.
+func (i DockerImage) DockerfilePath() string {
+	if i.Dockerfile != "" {
+		return i.Dockerfile
+	}
+	return path.Join(i.Context, "Dockerfile")
+}
+
+// MultiPlatform checks if image is built for platforms other than the runner
+// platform, which requires emulation.
+func (i DockerImage) MultiPlatform() bool {
+	for _, p := range i.Platforms {
+		if p != DefaultPlatform {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputKey returns identifier of the image usable in step output names.
+func (i DockerImage) OutputKey() string {
+	return strings.ToLower(identifier(i.Name))
+}
+
+// UnmarshalYAML accepts image name as a shorthand for an image with default
+// settings.
+func (i *DockerImage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*i = DockerImage{Name: name}
+		return nil
+	}
+	type plain DockerImage
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*i = DockerImage(p)
+	return nil
+}
+
+func (c *CiConfig) normalizeImages() error {
+	if c.Registries == nil {
+		c.Registries = make(map[string]Registry)
+	}
+	for id, r := range defaultRegistries() {
+		if _, ok := c.Registries[id]; !ok {
+			c.Registries[id] = r
+		}
+	}
+	for id, r := range c.Registries {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid registry %s: %w", id, err)
+		}
+	}
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for i := range c.DockerImages {
+		img := &c.DockerImages[i]
+		if img.Name == "" {
+			return fmt.Errorf("docker image #%d has no name", i+1)
+		}
+		// images of the same name would overwrite each other
+		if names[img.Name] {
+			return fmt.Errorf("duplicate docker image %s", img.Name)
+		}
+		names[img.Name] = true
+		// output key names step ids, job outputs and env vars of the image
+		if other, ok := keys[img.OutputKey()]; ok {
+			return fmt.Errorf("docker images %s and %s differ only in case or punctuation", other, img.Name)
+		}
+		keys[img.OutputKey()] = img.Name
+		if len(img.Registries) == 0 {
+			img.Registries = []string{DefaultRegistry}
+		}
+		for _, id := range img.Registries {
+			if _, ok := c.Registries[id]; !ok {
+				return fmt.Errorf("docker image %s uses unknown registry %s", img.Name, id)
+			}
+		}
+		if !img.BuiltByGenerator() {
+			if img.Dockerfile != "" || len(img.Platforms) != 0 || len(img.BuildArgs) != 0 {
+				return fmt.Errorf("docker image %s has build settings, but no context", img.Name)
+			}
+			continue
+		}
+		if len(img.Platforms) == 0 {
+			img.Platforms = []string{DefaultPlatform}
+		}
+		for _, p := range img.Platforms {
+			if !platformRegexp.MatchString(p) {
+				return fmt.Errorf("docker image %s has invalid platform %q", img.Name, p)
+			}
+		}
+	}
+	return nil
+}
+
+// UsedRegistries returns ids of registries at least one image is pushed to,
+// in sorted order.
+func (c CiConfig) UsedRegistries() []string {
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, img := range c.DockerImages {
+		for _, id := range img.Registries {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+)
+
+// E2eConfig describes e2e tests. Build script puts artifacts into
+// e2e-artifacts, which are then passed to run scripts of every suite.
+type E2eConfig struct {
+	BuildScript string `yaml:"buildScript"`
+	RunScript   string `yaml:"runScript"`
+	// RetentionDays is how long artifacts and logs are kept
+	RetentionDays int `yaml:"retentionDays"`
+	// Shards splits run into parallel jobs, which get E2E_SHARD (1-based)
+	// and E2E_TOTAL environment variables
+	Shards int `yaml:"shards"`
+	// Services are containers available to run jobs, e.g. databases
+	Services map[string]actions.Container `yaml:"services"`
+	// Suites run separately, each in its own job. Unset suite fields are
+	// inherited from the top level. If empty, single suite is run.
+	Suites []E2eSuite `yaml:"suites"`
+	// Kind makes run jobs provision a Kubernetes cluster
+	Kind *KindConfig `yaml:"kind"`
+}
+
+// KindConfig describes kind cluster e2e tests run against. Images listed in
+// dockerImages are loaded into the cluster tagged as `<name>:e2e`. Images
+// without context must be built with this tag by the build script.
+type KindConfig struct {
+	// NodeImage selects Kubernetes version, e.g. kindest/node:v1.29.2
+	NodeImage string `yaml:"nodeImage"`
+	// Chart is path to Helm chart installed before tests run
+	Chart string `yaml:"chart"`
+	// ChartValues is path to values file for the chart
+	ChartValues string `yaml:"chartValues"`
+	// Release is name of the Helm release, defaults to e2e
+	Release string `yaml:"release"`
+}
+
+type E2eSuite struct {
+	Name      string                       `yaml:"name"`
+	RunScript string                       `yaml:"runScript"`
+	Shards    int                          `yaml:"shards"`
+	Services  map[string]actions.Container `yaml:"services"`
+}
+
+// DefaultSuite is run when no suites are listed.
+func (e E2eConfig) DefaultSuite() E2eSuite {
+	return E2eSuite{
+		RunScript: e.RunScript,
+		Shards:    e.Shards,
+		Services:  e.Services,
+	}
+}
+
+var suiteNameRegexp = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+
+func (e *E2eConfig) normalize() error {
+	if e.BuildScript == "" {
+		e.BuildScript = "ci/e2e-build.sh"
+	}
+	if e.RunScript == "" {
+		e.RunScript = "ci/e2e-run.sh"
+	}
+	if e.RetentionDays == 0 {
+		e.RetentionDays = 2
+	}
+	if e.Shards == 0 {
+		e.Shards = 1
+	}
+	if e.RetentionDays < 0 || e.Shards < 0 {
+		return fmt.Errorf("e2e retention and shards must be positive")
+	}
+	if e.Kind != nil {
+		if e.Kind.Release == "" {
+			e.Kind.Release = "e2e"
+		}
+		if e.Kind.ChartValues != "" && e.Kind.Chart == "" {
+			return fmt.Errorf("e2e.kind.chartValues set without chart")
+		}
+	}
+	seen := make(map[string]bool)
+	for i := range e.Suites {
+		s := &e.Suites[i]
+		if !suiteNameRegexp.MatchString(s.Name) {
+			return fmt.Errorf("invalid e2e suite name %q", s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate e2e suite %s", s.Name)
+		}
+		seen[s.Name] = true
+		if s.RunScript == "" {
+			s.RunScript = e.RunScript
+		}
+		if s.Shards == 0 {
+			s.Shards = e.Shards
+		}
+		if s.Shards < 0 {
+			return fmt.Errorf("e2e suite %s: shards must be positive", s.Name)
+		}
+		if s.Services == nil {
+			s.Services = e.Services
+		}
+	}
+	return nil
+}
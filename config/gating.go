@@ -0,0 +1,49 @@
+package config
+
+import "fmt"
+
+const (
+	GatingBors       = "bors"
+	GatingMergeQueue = "mergeQueue"
+)
+
+// MergeQueueConfig describes GitHub merge queue used instead of bors.
+type MergeQueueConfig struct {
+	// Branch is the protected branch, defaults to master
+	Branch string `yaml:"branch"`
+	// MergeMethod is one of MERGE, SQUASH and REBASE
+	MergeMethod            string `yaml:"mergeMethod"`
+	RequiredApprovals      int    `yaml:"requiredApprovals"`
+	RequireCodeOwnerReview bool   `yaml:"requireCodeOwnerReview"`
+	// RulesetPath is where ruleset JSON is written, relative to the
+	// repository root
+	RulesetPath string `yaml:"rulesetPath"`
+}
+
+func (c *CiConfig) normalizeGating() error {
+	switch c.MergeGating {
+	case "":
+		c.MergeGating = GatingBors
+	case GatingBors, GatingMergeQueue:
+	default:
+		return fmt.Errorf("unknown merge gating %q, expected %s or %s", c.MergeGating, GatingBors, GatingMergeQueue)
+	}
+	q := &c.MergeQueue
+	if q.Branch == "" {
+		q.Branch = "master"
+	}
+	switch q.MergeMethod {
+	case "":
+		q.MergeMethod = "MERGE"
+	case "MERGE", "SQUASH", "REBASE":
+	default:
+		return fmt.Errorf("unknown merge method %q", q.MergeMethod)
+	}
+	if q.RequiredApprovals < 0 {
+		return fmt.Errorf("requiredApprovals must not be negative")
+	}
+	if q.RulesetPath == "" {
+		q.RulesetPath = "ci/ruleset.json"
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReleaseConfig describes binaries published to GitHub Releases on `v*` tags.
+type ReleaseConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// GoTargets lists GOOS/GOARCH pairs, e.g. linux/amd64
+	GoTargets []string `yaml:"goTargets"`
+	// GoPackage is the main package to build, relative to the repository root
+	GoPackage string `yaml:"goPackage"`
+	// RustTargets lists target triples, e.g. x86_64-unknown-linux-gnu
+	RustTargets []string `yaml:"rustTargets"`
+	// RustBinaries lists names of cargo binaries included into the release
+	RustBinaries []string `yaml:"rustBinaries"`
+}
+
+func (r *ReleaseConfig) normalize() error {
+	if !r.Enabled {
+		return nil
+	}
+	if len(r.GoTargets) == 0 {
+		r.GoTargets = []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64", "windows/amd64"}
+	}
+	for _, t := range r.GoTargets {
+		if len(strings.Split(t, "/")) != 2 {
+			return fmt.Errorf("invalid go target %q, expected GOOS/GOARCH", t)
+		}
+	}
+	if r.GoPackage == "" {
+		r.GoPackage = "."
+	}
+	if len(r.RustTargets) == 0 {
+		r.RustTargets = []string{"x86_64-unknown-linux-gnu", "aarch64-unknown-linux-gnu", "x86_64-apple-darwin", "x86_64-pc-windows-msvc"}
+	}
+	for _, t := range r.RustTargets {
+		if len(strings.Split(t, "-")) < 3 {
+			return fmt.Errorf("invalid rust target %q", t)
+		}
+	}
+	return nil
+}
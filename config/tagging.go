@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// TaggingPolicy describes which tags are assigned to published images.
+type TaggingPolicy struct {
+	// Branches maps branch names to image tags. Pushes to branches not listed
+	// here do not publish images.
+	Branches map[string]string `yaml:"branches"`
+	// Sha adds `sha-<short commit hash>` tag
+	Sha bool `yaml:"sha"`
+	// Semver publishes images on `v*` git tags with `1.2.3`, `1.2` and `1`
+	// tags
+	Semver bool `yaml:"semver"`
+	// Date adds tag with current UTC date formatted using DateFormat
+	Date       bool   `yaml:"date"`
+	DateFormat string `yaml:"dateFormat"`
+}
+
+// defaultTaggingPolicy publishes merged commits as latest. With bors, try
+// builds are published as dev; merge queue has no such branch.
+func (c *CiConfig) defaultTaggingPolicy() TaggingPolicy {
+	if c.MergeGating == GatingMergeQueue {
+		return TaggingPolicy{
+			Branches: map[string]string{c.MergeQueue.Branch: "latest"},
+		}
+	}
+	return TaggingPolicy{
+		Branches: map[string]string{
+			"master": "latest",
+			"trying": "dev",
+		},
+	}
+}
+
+// docker tags are limited to 128 characters of this alphabet
+var dockerTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+func (p *TaggingPolicy) normalize(defaults TaggingPolicy) error {
+	if p.Branches == nil {
+		p.Branches = defaults.Branches
+	}
+	for branch, tag := range p.Branches {
+		if !dockerTagRegexp.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q for branch %s", tag, branch)
+		}
+	}
+	if p.DateFormat == "" {
+		p.DateFormat = "%Y%m%d"
+	}
+	return nil
+}
+
+// TaggedBranches returns sorted list of branches images are published from.
+func (p TaggingPolicy) TaggedBranches() []string {
+	branches := make([]string, 0, len(p.Branches))
+	for branch := range p.Branches {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+	return branches
+}
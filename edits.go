@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const generatedHeader = "# GENERATED FILE DO NOT EDIT"
+
+var headerRegexp = regexp.MustCompile(`^# GENERATED FILE DO NOT EDIT sha256:([0-9a-f]{64})\n`)
+
+// stampable checks if file supports `#` comments, so that header with hash of
+// the content can be embedded into it.
+func stampable(name string) bool {
+	switch path.Ext(name) {
+	case ".yaml", ".yml", ".toml", ".sh":
+		return true
+	}
+	return false
+}
+
+// stamp prepends header with hash of the content.
+func stamp(body []byte) []byte {
+	header := fmt.Sprintf("%s sha256:%s\n", generatedHeader, hashContent(body))
+	return append([]byte(header), body...)
+}
+
+// unstamp splits file into hash from the header and content. ok is false if
+// file has no header.
+func unstamp(data []byte) (hash string, body []byte, ok bool) {
+	m := headerRegexp.FindSubmatch(data)
+	if m == nil {
+		return "", nil, false
+	}
+	return string(m[1]), data[len(m[0]):], true
+}
+
+// handEdit is a generated file changed after generation.
+type handEdit struct {
+	name string
+	// current is content of the file without header
+	current []byte
+	// generated is content produced by the generator now
+	generated []byte
+}
+
+// findHandEdits returns files whose content does not match hash embedded
+// into them, unless the generator produces the same content anyway.
+func findHandEdits(out string, files *generatedFiles) ([]handEdit, error) {
+	edits := make([]handEdit, 0)
+	for _, name := range files.names() {
+		if !stampable(name) {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(out, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hash, current, ok := unstamp(data)
+		if !ok || hash == hashContent(current) {
+			continue
+		}
+		_, generated, _ := unstamp(files.data(name))
+		if bytes.Equal(current, generated) {
+			continue
+		}
+		edits = append(edits, handEdit{name: name, current: current, generated: generated})
+	}
+	return edits, nil
+}
+
+func withoutFiles(edits []handEdit, names []string) []handEdit {
+	res := make([]handEdit, 0, len(edits))
+	for _, e := range edits {
+		if !contains(names, e.name) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func reportHandEdits(edits []handEdit) {
+	for _, e := range edits {
+		log.Printf("%s was edited by hand:\n%s", e.name, lineDiff(e.generated, e.current))
+	}
+}
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// lineDiff returns unified-style diff between a and b with three lines of
+// context around changes.
+func lineDiff(a, b []byte) string {
+	x := strings.Split(strings.TrimSuffix(string(a), "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	// lcs[i][j] is length of common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ops := make([]diffOp, 0, len(x)+len(y))
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, diffOp{' ', x[i]})
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, diffOp{'+', y[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{'-', x[i]})
+			i++
+		}
+	}
+
+	const context = 3
+	show := make([]bool, len(ops))
+	for k, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		for p := k - context; p <= k+context; p++ {
+			if p >= 0 && p < len(ops) {
+				show[p] = true
+			}
+		}
+	}
+	var sb strings.Builder
+	for k, op := range ops {
+		if !show[k] {
+			continue
+		}
+		if k == 0 || !show[k-1] {
+			sb.WriteString("@@\n")
+		}
+		fmt.Fprintf(&sb, "%c%s\n", op.kind, op.line)
+	}
+	return sb.String()
+}
+
+func runCheckEdits(args []string) {
+	flags := flag.NewFlagSet("check-edits", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository")
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+	edits, err := findHandEdits(*repoRoot, generate(*repoRoot).files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(edits) != 0 {
+		reportHandEdits(edits)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+)
+
+// explain prints origin of every generated job and required checks it
+// reports.
+func explain(w io.Writer, g generation) error {
+	required := make(map[string]bool)
+	for _, jobName := range g.gate.Jobs() {
+		required[jobName] = true
+	}
+	for _, workflow := range g.workflows {
+		fmt.Fprintf(w, "workflow %s (.github/workflows/%s.yaml)\n", workflow.Name, workflow.Name)
+		for _, jobName := range actions.OrderJobs(workflow.Jobs) {
+			job := workflow.Jobs[jobName]
+			fmt.Fprintf(w, "  job %s\n", jobName)
+			for _, reason := range job.Provenance {
+				fmt.Fprintf(w, "    from: %s\n", reason)
+			}
+			if !required[jobName] {
+				continue
+			}
+			checks, err := job.CheckNames(jobName)
+			if err != nil {
+				return err
+			}
+			for _, check := range checks {
+				fmt.Fprintf(w, "    required check: %s\n", check)
+			}
+		}
+	}
+	disabled := make([]string, 0)
+	for jobName, o := range g.overrides.overrides {
+		if o.Disabled {
+			disabled = append(disabled, jobName)
+		}
+	}
+	sort.Strings(disabled)
+	for _, jobName := range disabled {
+		fmt.Fprintf(w, "job %s disabled by overrides.%s in %s\n", jobName, jobName, config.ConfigPath)
+	}
+	return nil
+}
+
+func runExplain(args []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository")
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+	if err := explain(os.Stdout, generate(*repoRoot)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package fileset writes a group of files to disk as a single unit.
+package fileset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ModeFile       os.FileMode = 0o644
+	ModeExecutable os.FileMode = 0o755
+)
+
+// ModeFor returns mode for file with given name: scripts are executable,
+// everything else is not.
+func ModeFor(name string) os.FileMode {
+	if path.Ext(name) == ".sh" {
+		return ModeExecutable
+	}
+	return ModeFile
+}
+
+// Normalize converts line endings to LF and makes non-empty content end with
+// exactly one newline.
+func Normalize(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return data
+	}
+	return append(data, '\n')
+}
+
+type file struct {
+	data []byte
+	mode os.FileMode
+}
+
+// FileSet collects files keyed by slash-separated path relative to the
+// output directory.
+type FileSet struct {
+	files map[string]file
+}
+
+func New() *FileSet {
+	return &FileSet{files: make(map[string]file)}
+}
+
+// Add normalizes content and adds file with mode chosen by ModeFor.
+func (s *FileSet) Add(name string, data []byte) error {
+	if _, ok := s.files[name]; ok {
+		return fmt.Errorf("file %s is added twice", name)
+	}
+	if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid file name %s", name)
+	}
+	s.files[name] = file{data: Normalize(data), mode: ModeFor(name)}
+	return nil
+}
+
+// Data returns content of the file, or nil if it is not in the set.
+func (s *FileSet) Data(name string) []byte {
+	return s.files[name].data
+}
+
+func (s *FileSet) Has(name string) bool {
+	_, ok := s.files[name]
+	return ok
+}
+
+// Names returns sorted names of all files.
+func (s *FileSet) Names() []string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write creates missing directories and writes all files under root. Every
+// file is first written to a temporary file next to its destination, and
+// existing files are backed up. Temporary files are renamed only after all of
+// them were written, and if renaming fails, renamed files are restored from
+// backups, so a failure leaves existing files untouched. Created directories
+// are kept.
+func (s *FileSet) Write(root string) error {
+	staged := make(map[string]string)
+	// backups of replaced files, files without backup did not exist
+	backups := make(map[string]string)
+	cleanup := func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+		for _, backup := range backups {
+			os.Remove(backup)
+		}
+	}
+	for _, name := range s.Names() {
+		tmp, err := s.stage(root, name)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		staged[name] = tmp
+		backup, err := backUp(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+		if backup != "" {
+			backups[name] = backup
+		}
+	}
+	renamed := make([]string, 0, len(staged))
+	for _, name := range s.Names() {
+		if err := os.Rename(staged[name], filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			if restoreErr := restore(root, renamed, backups); restoreErr != nil {
+				err = fmt.Errorf("%v, and failed to restore previous files: %w", err, restoreErr)
+			}
+			cleanup()
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		delete(staged, name)
+		renamed = append(renamed, name)
+	}
+	cleanup()
+	return nil
+}
+
+// backUp copies existing file to a temporary file next to it. It returns
+// empty name if there is no file to back up; directories are not replaced
+// by rename, so they need no backup.
+func backUp(dest string) (string, error) {
+	info, err := os.Stat(dest)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".bak*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// restore brings back files replaced by Write, and removes files it created.
+func restore(root string, renamed []string, backups map[string]string) error {
+	for _, name := range renamed {
+		dest := filepath.Join(root, filepath.FromSlash(name))
+		var err error
+		if backup, ok := backups[name]; ok {
+			err = os.Rename(backup, dest)
+			delete(backups, name)
+		} else {
+			err = os.Remove(dest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSet) stage(root string, name string) (string, error) {
+	dest := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(s.files[name].data)
+	if err == nil {
+		err = f.Chmod(s.files[name].mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
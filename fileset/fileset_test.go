@@ -0,0 +1,70 @@
+package fileset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteSetsModesAndNormalizes(t *testing.T) {
+	root := t.TempDir()
+	s := New()
+	assert.NilError(t, s.Add(".github/workflows/ci.yaml", []byte("a: 1\r\nb: 2\n\n\n")))
+	assert.NilError(t, s.Add("ci/build.sh", []byte("echo ok")))
+	assert.NilError(t, s.Write(root))
+
+	data, err := os.ReadFile(filepath.Join(root, ".github/workflows/ci.yaml"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "a: 1\nb: 2\n")
+	info, err := os.Stat(filepath.Join(root, ".github/workflows/ci.yaml"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), ModeFile)
+	info, err = os.Stat(filepath.Join(root, "ci/build.sh"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), ModeExecutable)
+}
+
+func TestWriteIsAllOrNothing(t *testing.T) {
+	root := t.TempDir()
+	// directory can not be created where a file exists
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "ci"), nil, 0o644))
+	s := New()
+	assert.NilError(t, s.Add("a.txt", []byte("a")))
+	assert.NilError(t, s.Add("ci/b.txt", []byte("b")))
+	assert.ErrorContains(t, s.Write(root), "ci/b.txt")
+
+	entries, err := os.ReadDir(root)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+
+	assert.ErrorContains(t, s.Add("../c.txt", nil), "invalid file name")
+}
+
+func TestWriteRestoresReplacedFiles(t *testing.T) {
+	root := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("old\n"), 0o600))
+	// file can not replace non-empty directory, so the last rename fails
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "z", "dir"), 0o755))
+	s := New()
+	assert.NilError(t, s.Add("a.txt", []byte("new")))
+	assert.NilError(t, s.Add("b.txt", []byte("b")))
+	assert.NilError(t, s.Add("z", []byte("z")))
+	assert.ErrorContains(t, s.Write(root), "failed to write z")
+
+	data, err := os.ReadFile(filepath.Join(root, "a.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "old\n")
+	info, err := os.Stat(filepath.Join(root, "a.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+	// neither new files nor temporary files are left
+	entries, err := os.ReadDir(root)
+	assert.NilError(t, err)
+	names := make([]string, 0)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.DeepEqual(t, names, []string{"a.txt", "z"})
+}
@@ -0,0 +1,22 @@
+// Package gating describes how pull requests are checked before merge.
+package gating
+
+import "github.com/jjs-dev/ci-config-gen/actions"
+
+// Gate collects status checks required for merge and produces files which
+// configure the merge gating service.
+type Gate interface {
+	// AddJob registers job which must pass before merge.
+	AddJob(jobName string)
+	RemoveJob(jobName string)
+	// Jobs returns registered jobs.
+	Jobs() []string
+	// PatchTrigger adds events required to run checks on merge candidates.
+	PatchTrigger(t *actions.Trigger)
+	// Resolve computes check names reported by registered jobs and checks
+	// that they run on merge candidates.
+	Resolve(workflows []actions.Workflow) error
+	// Files returns generated files keyed by path relative to the repository
+	// root.
+	Files() (map[string][]byte, error)
+}
@@ -0,0 +1,146 @@
+package gating
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+)
+
+// MergeQueue gates merges using GitHub merge queue. It produces a repository
+// ruleset, which can be applied with the REST API or Terraform.
+type MergeQueue struct {
+	Settings config.MergeQueueConfig
+	// Timeout of a single check, in minutes
+	Timeout int
+	// Checks are names of required checks, computed by Resolve
+	Checks []string
+	jobs   []string
+}
+
+func (q *MergeQueue) AddJob(jobName string) {
+	q.jobs = append(q.jobs, jobName)
+}
+
+func (q *MergeQueue) RemoveJob(jobName string) {
+	jobs := make([]string, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		if j != jobName {
+			jobs = append(jobs, j)
+		}
+	}
+	q.jobs = jobs
+}
+
+func (q *MergeQueue) Jobs() []string {
+	return q.jobs
+}
+
+func (q *MergeQueue) Resolve(workflows []actions.Workflow) error {
+	checks, err := actions.ResolveCheckNames(workflows, q.jobs)
+	if err != nil {
+		return err
+	}
+	q.Checks = checks
+	return q.validate(workflows)
+}
+
+func (q *MergeQueue) PatchTrigger(t *actions.Trigger) {
+	t.MergeGroup = &actions.MergeGroupTrigger{}
+}
+
+func (q *MergeQueue) validate(workflows []actions.Workflow) error {
+	for _, jobName := range q.jobs {
+		for _, w := range workflows {
+			if _, ok := w.Jobs[jobName]; ok && w.On.MergeGroup == nil {
+				return fmt.Errorf("required job %s does not run on merge_group", jobName)
+			}
+		}
+	}
+	return nil
+}
+
+type rulesetCondition struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type rule struct {
+	Type       string      `json:"type"`
+	Parameters interface{} `json:"parameters,omitempty"`
+}
+
+type requiredCheck struct {
+	Context string `json:"context"`
+}
+
+// ruleset follows schema of the GitHub repository rulesets API.
+type ruleset struct {
+	Name        string `json:"name"`
+	Target      string `json:"target"`
+	Enforcement string `json:"enforcement"`
+	Conditions  struct {
+		RefName rulesetCondition `json:"ref_name"`
+	} `json:"conditions"`
+	Rules []rule `json:"rules"`
+}
+
+func (q *MergeQueue) ruleset() ruleset {
+	r := ruleset{
+		Name:        "merge-queue",
+		Target:      "branch",
+		Enforcement: "active",
+	}
+	r.Conditions.RefName = rulesetCondition{
+		Include: []string{"refs/heads/" + q.Settings.Branch},
+		Exclude: []string{},
+	}
+	checks := make([]requiredCheck, 0, len(q.Checks))
+	for _, c := range q.Checks {
+		checks = append(checks, requiredCheck{Context: c})
+	}
+	r.Rules = []rule{
+		{Type: "deletion"},
+		{Type: "non_fast_forward"},
+		{
+			Type: "pull_request",
+			Parameters: map[string]interface{}{
+				"required_approving_review_count":   q.Settings.RequiredApprovals,
+				"require_code_owner_review":         q.Settings.RequireCodeOwnerReview,
+				"dismiss_stale_reviews_on_push":     false,
+				"require_last_push_approval":        false,
+				"required_review_thread_resolution": false,
+			},
+		},
+		{
+			Type: "required_status_checks",
+			Parameters: map[string]interface{}{
+				"strict_required_status_checks_policy": false,
+				"required_status_checks":               checks,
+			},
+		},
+		{
+			Type: "merge_queue",
+			Parameters: map[string]interface{}{
+				"merge_method":                      q.Settings.MergeMethod,
+				"grouping_strategy":                 "ALLGREEN",
+				"max_entries_to_build":              5,
+				"min_entries_to_merge":              1,
+				"max_entries_to_merge":              5,
+				"min_entries_to_merge_wait_minutes": 5,
+				"check_response_timeout_minutes":    q.Timeout,
+			},
+		},
+	}
+	return r
+}
+
+func (q *MergeQueue) Files() (map[string][]byte, error) {
+	data, err := json.MarshalIndent(q.ruleset(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	return map[string][]byte{q.Settings.RulesetPath: data}, nil
+}
@@ -5,5 +5,6 @@ go 1.16
 require (
 	github.com/pelletier/go-toml v1.9.0
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 	gotest.tools/v3 v3.0.3
 )
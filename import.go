@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/languages"
+)
+
+// fixedCiJobs are generated regardless of languages, so custom jobs can not
+// use their names.
+var fixedCiJobs = map[string]bool{
+	"misspell":     true,
+	"e2e-build":    true,
+	"e2e-run":      true,
+	"ci-success":   true,
+	changesJobName: true,
+}
+
+// importedJob is a job of hand-written workflow with workflow-level settings
+// pushed down into it.
+type importedJob struct {
+	file string
+	key  string
+	job  actions.Job
+}
+
+// importer converts hand-written workflows into overrides of generated jobs
+// and custom jobs.
+type importer struct {
+	// generated are language jobs the generator would produce, by key
+	generated  map[string]actions.Job
+	matched    map[string]bool
+	overrides  map[string]config.JobOverride
+	customJobs map[string]actions.Job
+	// problems describe parts of workflows which can not be represented
+	problems []string
+}
+
+func newImporter(repoRoot string, cfg config.CiConfig) *importer {
+	im := &importer{
+		generated:  make(map[string]actions.Job),
+		matched:    make(map[string]bool),
+		overrides:  make(map[string]config.JobOverride),
+		customJobs: make(map[string]actions.Job),
+	}
+	for _, lang := range languages.MakeLanguages() {
+		if !lang.Used(repoRoot) {
+			continue
+		}
+		for _, job := range lang.Make(repoRoot, cfg).CI {
+			job.RunsOn = cfg.Runners.Resolve(lang.Name(), job.Name)
+			im.generated[job.Name] = job
+		}
+	}
+	return im
+}
+
+func (im *importer) reportf(format string, args ...interface{}) {
+	im.problems = append(im.problems, fmt.Sprintf(format, args...))
+}
+
+// triggerProblems lists parts of `on` which differ from triggers of the ci
+// workflow.
+func triggerProblems(on actions.Trigger) []string {
+	res := make([]string, 0)
+	if on.PullRequestTarget != nil {
+		res = append(res, "pull_request_target")
+	}
+	if on.PullRequest != nil {
+		pr := on.PullRequest
+		if len(pr.Branches) != 0 || len(pr.BranchesIgnore) != 0 || len(pr.Paths) != 0 || len(pr.PathsIgnore) != 0 || len(pr.Types) != 0 {
+			res = append(res, "pull_request filters")
+		}
+	}
+	if on.Push != nil {
+		push := on.Push
+		if len(push.Tags) != 0 || len(push.TagsIgnore) != 0 {
+			res = append(res, "push tags")
+		}
+		if len(push.BranchesIgnore) != 0 || len(push.Paths) != 0 || len(push.PathsIgnore) != 0 {
+			res = append(res, "push filters")
+		}
+	}
+	events := make([]string, 0, len(on.Other))
+	for e := range on.Other {
+		events = append(events, e)
+	}
+	sort.Strings(events)
+	return append(res, events...)
+}
+
+// loadHandWrittenJobs parses workflow and pushes env, defaults and
+// permissions of the workflow into its jobs.
+func (im *importer) loadHandWrittenJobs(repoRoot, file string) ([]importedJob, error) {
+	data, err := os.ReadFile(path.Join(repoRoot, file))
+	if err != nil {
+		return nil, err
+	}
+	w, err := actions.ParseWorkflow(data)
+	if err != nil {
+		im.reportf("%s: skipped, failed to parse: %v", file, err)
+		return nil, nil
+	}
+	if _, err := actions.ParseWorkflowStrict(data); err != nil {
+		im.reportf("%s: unsupported keys are dropped: %v", file, err)
+	}
+	if problems := triggerProblems(w.On); len(problems) != 0 {
+		im.reportf("%s: jobs will run on ci triggers instead of %s", file, strings.Join(problems, ", "))
+	}
+	if w.Concurrency != nil {
+		im.reportf("%s: workflow concurrency is dropped", file)
+	}
+	// generated ci workflow already has read-only permissions
+	permissions := w.Permissions
+	if reflect.DeepEqual(permissions, actions.ReadOnlyPermissions()) {
+		permissions = nil
+	}
+	jobKeys := make([]string, 0, len(w.Jobs))
+	for key := range w.Jobs {
+		jobKeys = append(jobKeys, key)
+	}
+	sort.Strings(jobKeys)
+	res := make([]importedJob, 0, len(jobKeys))
+	for _, key := range jobKeys {
+		job := w.Jobs[key]
+		if len(w.Env) != 0 {
+			env := make(map[string]string)
+			for k, v := range w.Env {
+				env[k] = v
+			}
+			for k, v := range job.Env {
+				env[k] = v
+			}
+			job.Env = env
+		}
+		if job.Defaults == nil {
+			job.Defaults = w.Defaults
+		}
+		if job.Permissions == nil {
+			job.Permissions = permissions
+		}
+		res = append(res, importedJob{file: file, key: key, job: job})
+	}
+	return res, nil
+}
+
+// stepMatches checks if hand-written step does the same as generated one.
+// Action versions are ignored.
+func stepMatches(generated, hand actions.Step) bool {
+	if generated.Uses != "" {
+		g, gok := actions.ParseActionRef(generated.Uses)
+		h, hok := actions.ParseActionRef(hand.Uses)
+		if !gok || !hok {
+			return generated.Uses == hand.Uses
+		}
+		return g.Repo == h.Repo
+	}
+	return generated.Run != "" && strings.TrimSpace(generated.Run) == strings.TrimSpace(hand.Run)
+}
+
+// stepPositions finds all generated steps in hand-written job, in order.
+func stepPositions(generated, hand actions.Job) ([]int, bool) {
+	positions := make([]int, 0, len(generated.Steps))
+	next := 0
+	for _, step := range generated.Steps {
+		for next < len(hand.Steps) && !stepMatches(step, hand.Steps[next]) {
+			next++
+		}
+		if next == len(hand.Steps) {
+			return nil, false
+		}
+		positions = append(positions, next)
+		next++
+	}
+	return positions, len(positions) != 0
+}
+
+// findGeneratedJob returns key of not yet matched generated job which the
+// hand-written job resembles. Job with the same key is preferred.
+func (im *importer) findGeneratedJob(hand importedJob) (string, []int, bool) {
+	keys := make([]string, 0, len(im.generated))
+	for key := range im.generated {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if _, ok := im.generated[hand.key]; ok {
+		keys = append([]string{hand.key}, keys...)
+	}
+	for _, key := range keys {
+		if im.matched[key] {
+			continue
+		}
+		if positions, ok := stepPositions(im.generated[key], hand.job); ok {
+			return key, positions, true
+		}
+	}
+	return "", nil, false
+}
+
+// matchJob tries to express hand-written job as an override of generated
+// job. It returns key of that job.
+func (im *importer) matchJob(hand importedJob) (string, bool) {
+	genKey, positions, ok := im.findGeneratedJob(hand)
+	if !ok {
+		return "", false
+	}
+	generated := im.generated[genKey]
+	edited := hand.job
+	edited.Steps = append([]actions.Step(nil), hand.job.Steps...)
+	for i, pos := range positions {
+		if !reflect.DeepEqual(edited.Steps[pos].With, generated.Steps[i].With) {
+			im.reportf("%s: job %s: inputs of step %q are replaced with generated ones", hand.file, hand.key, generated.Steps[i].Name)
+		}
+		edited.Steps[pos] = generated.Steps[i]
+	}
+	if edited.Timeout == 0 {
+		edited.Timeout = generated.Timeout
+	}
+	if edited.Name != generated.Name {
+		if edited.Name != "" {
+			im.reportf("%s: job %s: name %q is replaced with %q, required checks must be updated", hand.file, hand.key, edited.Name, generated.Name)
+		}
+		edited.Name = generated.Name
+	}
+	o, err := jobEditOverride(generated, edited)
+	if err == nil {
+		var patched actions.Job
+		patched, err = applyOverride(generated, o)
+		if err == nil && !reflect.DeepEqual(patched, edited) {
+			err = errors.New("changes can not be expressed as override")
+		}
+	}
+	if err != nil {
+		im.reportf("%s: job %s resembles generated job %s, but %v; it is imported as custom job", hand.file, hand.key, genKey, err)
+		return "", false
+	}
+	im.matched[genKey] = true
+	if !reflect.DeepEqual(o, config.JobOverride{}) {
+		im.overrides[genKey] = o
+	}
+	return genKey, true
+}
+
+// customJobName picks name which does not clash with generated jobs.
+func (im *importer) customJobName(hand importedJob) string {
+	taken := func(name string) bool {
+		_, generated := im.generated[name]
+		_, custom := im.customJobs[name]
+		return generated || custom || fixedCiJobs[name]
+	}
+	if !taken(hand.key) {
+		return hand.key
+	}
+	prefix := strings.TrimSuffix(path.Base(hand.file), path.Ext(hand.file))
+	name := fmt.Sprintf("%s-%s", prefix, hand.key)
+	for i := 2; taken(name); i++ {
+		name = fmt.Sprintf("%s-%s-%d", prefix, hand.key, i)
+	}
+	return name
+}
+
+// importWorkflows processes hand-written workflows. Generated jobs which
+// have no counterpart are disabled.
+func (im *importer) importWorkflows(repoRoot string, files []string) error {
+	jobs := make([]importedJob, 0)
+	for _, file := range files {
+		fileJobs, err := im.loadHandWrittenJobs(repoRoot, file)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, fileJobs...)
+	}
+	// new names of hand-written jobs, by file and key
+	names := make(map[[2]string]string)
+	custom := make([]importedJob, 0)
+	for _, hand := range jobs {
+		if genKey, ok := im.matchJob(hand); ok {
+			names[[2]string{hand.file, hand.key}] = genKey
+		} else {
+			custom = append(custom, hand)
+		}
+	}
+	for _, hand := range custom {
+		name := im.customJobName(hand)
+		names[[2]string{hand.file, hand.key}] = name
+		// placeholder reserves the name, needs are fixed below
+		im.customJobs[name] = hand.job
+	}
+	// needs refer to jobs of the same file, which may have been renamed
+	for _, hand := range custom {
+		job := hand.job
+		// names of generated jobs must match their keys
+		if name := names[[2]string{hand.file, hand.key}]; job.Name != "" && job.Name != name {
+			im.reportf("%s: job %s: display name %q is dropped, required checks must be updated to %s", hand.file, hand.key, job.Name, name)
+			job.Name = ""
+		}
+		if len(job.Needs) != 0 {
+			needs := make(actions.StringList, 0, len(job.Needs))
+			for _, need := range job.Needs {
+				if renamed, ok := names[[2]string{hand.file, need}]; ok {
+					need = renamed
+				}
+				needs = append(needs, need)
+			}
+			job.Needs = needs
+		}
+		im.customJobs[names[[2]string{hand.file, hand.key}]] = job
+	}
+	for key := range im.generated {
+		if !im.matched[key] {
+			im.overrides[key] = config.JobOverride{Disabled: true}
+		}
+	}
+	return nil
+}
+
+// validateProposal builds the ci workflow from proposed config, so that a
+// config which generate would reject is never written.
+func validateProposal(repoRoot string, cfg config.CiConfig) error {
+	gate := makeGate(cfg)
+	w, err := newOverrideSet(cfg.Overrides).apply(makeCiWorkflow(languages.MakeLanguages(), cfg, repoRoot, gate), gate)
+	if err != nil {
+		return err
+	}
+	return w.Validate()
+}
+
+func runImportWorkflows(args []string) {
+	flags := flag.NewFlagSet("import-workflows", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository")
+	output := flags.String("output", "", "file to write proposed config to instead of stdout")
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+	inspection, err := inspectRepo(*repoRoot)
+	if err != nil {
+		log.Fatalf("failed to inspect repository: %v", err)
+	}
+	if len(inspection.workflows) == 0 {
+		log.Fatal("no hand-written workflows found")
+	}
+	base, err := os.ReadFile(path.Join(*repoRoot, config.ConfigPath))
+	if errors.Is(err, os.ErrNotExist) {
+		base = []byte(renderStarterConfig(*repoRoot, inspection))
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.Parse(base)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	im := newImporter(*repoRoot, cfg)
+	if err := im.importWorkflows(*repoRoot, inspection.workflows); err != nil {
+		log.Fatal(err)
+	}
+	proposal, err := mergeIntoConfig(base, im.overrides, im.customJobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proposed, err := config.Parse(proposal)
+	if err != nil {
+		log.Fatalf("proposed config is invalid: %v", err)
+	}
+	if err := validateProposal(*repoRoot, proposed); err != nil {
+		log.Fatalf("proposed config produces invalid workflow: %v", err)
+	}
+	for _, p := range im.problems {
+		log.Printf("can not represent: %s", p)
+	}
+	if *output == "" {
+		if _, err := os.Stdout.Write(proposal); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := os.WriteFile(*output, proposal, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote proposed config to %s, remove imported workflows %v after review", *output, inspection.workflows)
+}
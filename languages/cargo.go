@@ -0,0 +1,225 @@
+package languages
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// cargoManifest contains parts of Cargo.toml relevant for publishing.
+type cargoManifest struct {
+	name    string
+	publish bool
+	// pathDeps are names of workspace crates this crate depends on
+	pathDeps []string
+}
+
+func loadToml(p string) (map[string]interface{}, error) {
+	tree, err := toml.LoadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return tree.ToMap(), nil
+}
+
+func tomlTable(m map[string]interface{}, key string) map[string]interface{} {
+	t, _ := m[key].(map[string]interface{})
+	return t
+}
+
+// depIsLocal checks if dependency is located in the workspace, either
+// directly or via `workspace = true` inheritance.
+func depIsLocal(spec interface{}, workspaceDeps map[string]interface{}, name string) bool {
+	table, ok := spec.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok := table["path"]; ok {
+		return true
+	}
+	if inherit, _ := table["workspace"].(bool); inherit {
+		return depIsLocal(workspaceDeps[name], nil, name)
+	}
+	return false
+}
+
+// depName returns name of the crate referenced by the dependency entry,
+// taking renames into account.
+func depName(key string, spec interface{}) string {
+	if table, ok := spec.(map[string]interface{}); ok {
+		if pkg, ok := table["package"].(string); ok {
+			return pkg
+		}
+	}
+	return key
+}
+
+func parseCargoManifest(p string, workspaceDeps map[string]interface{}) (cargoManifest, error) {
+	m, err := loadToml(p)
+	if err != nil {
+		return cargoManifest{}, err
+	}
+	pkg := tomlTable(m, "package")
+	if pkg == nil {
+		return cargoManifest{}, fmt.Errorf("%s: no [package] section", p)
+	}
+	res := cargoManifest{publish: true}
+	res.name, _ = pkg["name"].(string)
+	if res.name == "" {
+		return cargoManifest{}, fmt.Errorf("%s: package name missing", p)
+	}
+	switch publish := pkg["publish"].(type) {
+	case bool:
+		res.publish = publish
+	case []interface{}:
+		// publishing is restricted to listed registries
+		res.publish = false
+		for _, r := range publish {
+			if r == "crates-io" {
+				res.publish = true
+			}
+		}
+	}
+	// dev-dependencies are stripped on publish, so they do not affect order
+	depTables := []map[string]interface{}{
+		tomlTable(m, "dependencies"),
+		tomlTable(m, "build-dependencies"),
+	}
+	for _, target := range tomlTable(m, "target") {
+		if t, ok := target.(map[string]interface{}); ok {
+			depTables = append(depTables, tomlTable(t, "dependencies"), tomlTable(t, "build-dependencies"))
+		}
+	}
+	for _, deps := range depTables {
+		for key, spec := range deps {
+			if depIsLocal(spec, workspaceDeps, key) {
+				res.pathDeps = append(res.pathDeps, depName(key, spec))
+			}
+		}
+	}
+	return res, nil
+}
+
+// cargoWorkspaceManifests returns paths to manifests of all workspace
+// members, and dependencies declared in [workspace.dependencies].
+func cargoWorkspaceManifests(root string) ([]string, map[string]interface{}, error) {
+	rootManifest := path.Join(root, "Cargo.toml")
+	m, err := loadToml(rootManifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	ws := tomlTable(m, "workspace")
+	if ws == nil {
+		return []string{rootManifest}, nil, nil
+	}
+	manifests := make([]string, 0)
+	if tomlTable(m, "package") != nil {
+		manifests = append(manifests, rootManifest)
+	}
+	// excluded paths are not members even if members globs match them
+	excluded := make([]string, 0)
+	excludes, _ := ws["exclude"].([]interface{})
+	for _, e := range excludes {
+		if p, ok := e.(string); ok {
+			excluded = append(excluded, filepath.Join(root, p))
+		}
+	}
+	members, _ := ws["members"].([]interface{})
+	for _, member := range members {
+		pattern, ok := member.(string)
+		if !ok {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(root, pattern, "Cargo.toml"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid workspace member %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if !underAny(filepath.Dir(match), excluded) {
+				manifests = append(manifests, match)
+			}
+		}
+	}
+	return manifests, tomlTable(ws, "dependencies"), nil
+}
+
+// underAny checks if dir is one of dirs or is inside one of them.
+func underAny(dir string, dirs []string) bool {
+	for _, d := range dirs {
+		if dir == d || strings.HasPrefix(dir, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishableCrate is a workspace crate published to crates.io.
+type PublishableCrate struct {
+	Name string
+	// HasWorkspaceDeps is set if the crate depends on other workspace
+	// crates, so it can only be packaged after they are published
+	HasWorkspaceDeps bool
+}
+
+// CratePublishOrder returns publishable crates of the cargo workspace at root,
+// ordered so that every crate goes after crates it depends on.
+func CratePublishOrder(root string) ([]PublishableCrate, error) {
+	manifestPaths, workspaceDeps, err := cargoWorkspaceManifests(root)
+	if err != nil {
+		return nil, err
+	}
+	crates := make(map[string]cargoManifest)
+	for _, p := range manifestPaths {
+		manifest, err := parseCargoManifest(p, workspaceDeps)
+		if err != nil {
+			return nil, err
+		}
+		crates[manifest.name] = manifest
+	}
+
+	order := make([]PublishableCrate, 0)
+	done := make(map[string]bool)
+	for len(done) < len(crates) {
+		ready := make([]string, 0)
+		for name, c := range crates {
+			if done[name] {
+				continue
+			}
+			blocked := false
+			for _, dep := range c.pathDeps {
+				if _, inWorkspace := crates[dep]; inWorkspace && !done[dep] {
+					blocked = true
+				}
+			}
+			if !blocked {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			return nil, fmt.Errorf("dependency cycle between workspace crates")
+		}
+		sort.Strings(ready)
+		for _, name := range ready {
+			done[name] = true
+			if !crates[name].publish {
+				continue
+			}
+			crate := PublishableCrate{Name: name}
+			for _, dep := range crates[name].pathDeps {
+				d, ok := crates[dep]
+				if ok && !d.publish {
+					return nil, fmt.Errorf("crate %s depends on unpublished crate %s", name, dep)
+				}
+				if ok {
+					crate.HasWorkspaceDeps = true
+				}
+			}
+			order = append(order, crate)
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,70 @@
+package languages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func writeFile(t *testing.T, p string, data string) {
+	assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	assert.NilError(t, os.WriteFile(p, []byte(data), 0o644))
+}
+
+func TestCratePublishOrder(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Cargo.toml"), `
+[workspace]
+members = ["crates/*", "tools/xtask"]
+exclude = ["crates/experimental"]
+
+[workspace.dependencies]
+util = { path = "crates/util" }
+`)
+	writeFile(t, filepath.Join(root, "crates/util/Cargo.toml"), `
+[package]
+name = "util"
+`)
+	writeFile(t, filepath.Join(root, "crates/client/Cargo.toml"), `
+[package]
+name = "my-client"
+
+[dependencies]
+api = { path = "../api", package = "api-types" }
+util = { workspace = true }
+`)
+	writeFile(t, filepath.Join(root, "crates/api/Cargo.toml"), `
+[package]
+name = "api-types"
+
+[target.'cfg(unix)'.dependencies]
+util = { path = "../util", version = "1" }
+
+[dev-dependencies]
+my-client = { path = "../client" }
+`)
+	writeFile(t, filepath.Join(root, "tools/xtask/Cargo.toml"), `
+[package]
+name = "xtask"
+publish = false
+
+[dependencies]
+my-client = { path = "../../crates/client" }
+`)
+	writeFile(t, filepath.Join(root, "crates/experimental/Cargo.toml"), `
+[package]
+name = "experimental"
+
+[dependencies]
+missing = { path = "../missing" }
+`)
+	order, err := CratePublishOrder(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, order, []PublishableCrate{
+		{Name: "util"},
+		{Name: "api-types", HasWorkspaceDeps: true},
+		{Name: "my-client", HasWorkspaceDeps: true},
+	})
+}
@@ -47,7 +47,6 @@ func (langCpp) Make(repoRoot string, config config.CiConfig) JobSet {
 	fmt.Println(m)
 	lintJob := actions.Job{
 		Name:    "cpp-lint",
-		RunsOn:  actions.UbuntuRunner,
 		Timeout: config.JobTimeout,
 		Steps: []actions.Step{
 			actions.MakeCheckoutStep(),
@@ -78,13 +77,22 @@ func (langCpp) Make(repoRoot string, config config.CiConfig) JobSet {
 	}
 	// TODO: upload report
 	lintJob.Steps = append(lintJob.Steps, stepCheckNoErrors)
+	sources := make([]string, 0, len(m))
+	paths := make([]string, 0, len(m))
+	for _, dir := range m {
+		dir = filepath.ToSlash(strings.TrimPrefix(dir, "/"))
+		sources = append(sources, dir+"/CMakeLists.txt")
+		paths = append(paths, dir+"/**")
+	}
 	return JobSet{
-		CI: []actions.Job{lintJob},
+		Source: strings.Join(sources, ", "),
+		Paths:  paths,
+		CI:     []actions.Job{lintJob},
 	}
 }
 
-func (langCpp) WriteAdditionalFiles(repoRoot string) error {
-	return nil
+func (langCpp) AdditionalFiles(repoRoot string) (map[string][]byte, error) {
+	return nil, nil
 }
 
 func makeLanguageForCpp() Language {
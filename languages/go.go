@@ -1,7 +1,11 @@
 package languages
 
 import (
+	"fmt"
+	"log"
+	"os"
 	"path"
+	"strings"
 
 	"github.com/jjs-dev/ci-config-gen/actions"
 	"github.com/jjs-dev/ci-config-gen/config"
@@ -20,26 +24,100 @@ func (langGo) Used(root string) bool {
 func MakeSetupGoStep() actions.Step {
 	return actions.Step{
 		Name: "Install golang",
-		Uses: "actions/setup-go@v2",
+		Uses: actions.SetupGo.Ref(),
 		With: map[string]string{
 			"go-version": "1.16.4",
 		},
 	}
 }
 
-func (langGo) Make(_repoRoot string, config config.CiConfig) JobSet {
+// GoModulePath returns module path declared in go.mod.
+func GoModulePath(root string) (string, error) {
+	data, err := os.ReadFile(path.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("go.mod does not declare module path")
+}
+
+// makeGoReleaseJob cross-compiles main package for every configured target.
+func makeGoReleaseJob(repoRoot string, config config.CiConfig) actions.Job {
+	modulePath, err := GoModulePath(repoRoot)
+	if err != nil {
+		log.Fatalf("failed to determine go module path: %v", err)
+	}
+	binary := path.Base(modulePath)
+	if config.Release.GoPackage != "." {
+		binary = path.Base(config.Release.GoPackage)
+	}
+	include := make([]map[string]string, 0)
+	for _, target := range config.Release.GoTargets {
+		parts := strings.Split(target, "/")
+		include = append(include, map[string]string{
+			"goos":   parts[0],
+			"goarch": parts[1],
+		})
+	}
+	build := `ext=""
+if [ "$GOOS" = "windows" ]
+then
+  ext=".exe"
+fi
+mkdir -p dist
+go build -trimpath -ldflags "-s -w" -o "dist/%s-$GOOS-$GOARCH$ext" %s`
+	pkg := path.Clean(config.Release.GoPackage)
+	if pkg != "." {
+		pkg = "./" + pkg
+	}
+	return actions.Job{
+		Name:    "release-go",
+		Timeout: config.JobTimeout,
+		Strategy: &actions.Strategy{
+			Matrix:   actions.Matrix{Include: include},
+			FailFast: actions.Bool(false),
+		},
+		Steps: []actions.Step{
+			actions.MakeCheckoutStep(),
+			MakeSetupGoStep(),
+			{
+				Name: "Build binary",
+				Run:  fmt.Sprintf(build, binary, pkg),
+				Env: map[string]string{
+					"GOOS":        "${{ matrix.goos }}",
+					"GOARCH":      "${{ matrix.goarch }}",
+					"CGO_ENABLED": "0",
+				},
+			},
+			makeUploadReleaseArtifactStep("release-go-${{ matrix.goos }}-${{ matrix.goarch }}"),
+		},
+	}
+}
+
+func (langGo) Make(repoRoot string, config config.CiConfig) JobSet {
+	var release []actions.Job
+	if config.Release.Enabled {
+		release = append(release, makeGoReleaseJob(repoRoot, config))
+	}
 	return JobSet{
+		Source:  "go.mod",
+		Paths:   []string{"**/*.go", "go.mod", "go.sum"},
+		Release: release,
 		CI: []actions.Job{
 			{
 				Name:    "go-lint",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					MakeSetupGoStep(),
 					{
 						Name: "Run linter",
-						Uses: "golangci/golangci-lint-action@v2",
+						Uses: actions.GolangciLint.Ref(),
 						With: map[string]string{
 							"version":              "latest",
 							"args":                 "--enable=gofmt",
@@ -50,7 +128,6 @@ func (langGo) Make(_repoRoot string, config config.CiConfig) JobSet {
 			},
 			{
 				Name:    "go-test",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
@@ -69,8 +146,8 @@ func (langGo) MakeE2eCacheStep() (bool, actions.Step) {
 	return false, actions.Step{}
 }
 
-func (langGo) WriteAdditionalFiles(repoRoot string) error {
-	return nil
+func (langGo) AdditionalFiles(repoRoot string) (map[string][]byte, error) {
+	return nil, nil
 }
 
 func makeLanguageForGo() Language {
@@ -5,8 +5,30 @@ import (
 	"github.com/jjs-dev/ci-config-gen/config"
 )
 
+// JobSet contains jobs generated for a language. Jobs do not specify runners,
+// they are assigned by the caller according to the config.
 type JobSet struct {
-	CI []actions.Job
+	// Source is the file which caused the language to be detected
+	Source string
+	// Paths are globs of files which affect CI jobs
+	Paths []string
+	CI    []actions.Job
+	// Release jobs build binaries for the release workflow. They must
+	// upload files to be attached to the release using
+	// makeUploadReleaseArtifactStep.
+	Release []actions.Job
+}
+
+func makeUploadReleaseArtifactStep(name string) actions.Step {
+	return actions.Step{
+		Name: "Upload binaries",
+		Uses: actions.UploadArtifact.Ref(),
+		With: map[string]string{
+			"name":           name,
+			"path":           "dist",
+			"retention-days": "2",
+		},
+	}
 }
 
 type Language interface {
@@ -14,7 +36,9 @@ type Language interface {
 	Used(repoRoot string) bool
 	Make(repoRoot string, config config.CiConfig) JobSet
 	MakeE2eCacheStep() (bool, actions.Step)
-	WriteAdditionalFiles(repoRoot string) error
+	// AdditionalFiles returns files generated besides workflows, keyed by
+	// path relative to the repository root
+	AdditionalFiles(repoRoot string) (map[string][]byte, error)
 }
 
 func MakeLanguages() []Language {
@@ -2,8 +2,8 @@ package languages
 
 import (
 	"fmt"
-	"os"
 	"path"
+	"strings"
 
 	"github.com/jjs-dev/ci-config-gen/actions"
 	"github.com/jjs-dev/ci-config-gen/config"
@@ -26,7 +26,7 @@ func (langRust) Used(root string) bool {
 func makeRustCacheStep() actions.Step {
 	return actions.Step{
 		Name: "Setup cache",
-		Uses: "Swatinem/rust-cache@v1",
+		Uses: actions.RustCache.Ref(),
 	}
 }
 
@@ -37,7 +37,7 @@ func (langRust) MakeE2eCacheStep() (bool, actions.Step) {
 func makeInstallTooclhainStep(channel string) actions.Step {
 	return actions.Step{
 		Name: fmt.Sprintf("Install %s toolchain", channel),
-		Uses: "actions-rs/toolchain@v1",
+		Uses: actions.RustToolchain.Ref(),
 		With: map[string]string{
 			"toolchain":  channel,
 			"components": "clippy,rustfmt",
@@ -46,7 +46,90 @@ func makeInstallTooclhainStep(channel string) actions.Step {
 	}
 }
 
+// rustTargetMatrixEntry selects runner and build tool for the target. Targets
+// which can not be built natively on the runner are built with cross.
+func rustTargetMatrixEntry(target string, config config.CiConfig) map[string]string {
+	entry := map[string]string{
+		"target": target,
+		"cargo":  "cargo",
+		"ext":    "",
+	}
+	switch {
+	case strings.HasSuffix(target, "-apple-darwin"):
+		entry["os"] = "macos-latest"
+	case strings.Contains(target, "-windows-"):
+		entry["os"] = "windows-latest"
+		entry["ext"] = ".exe"
+	default:
+		entry["os"] = actions.DefaultRunnerImage
+		if r := config.Runners.Resolve("rust", "release-rust"); r.Group == "" && len(r.Labels) == 1 {
+			entry["os"] = r.Labels[0]
+		}
+		if !strings.HasPrefix(target, "x86_64-") {
+			entry["cargo"] = "cross"
+		}
+	}
+	return entry
+}
+
+// makeRustReleaseJob builds configured binaries for every target.
+func makeRustReleaseJob(config config.CiConfig) actions.Job {
+	include := make([]map[string]string, 0)
+	for _, target := range config.Release.RustTargets {
+		include = append(include, rustTargetMatrixEntry(target, config))
+	}
+	copyCommands := []string{"mkdir -p dist"}
+	for _, bin := range config.Release.RustBinaries {
+		copyCommands = append(copyCommands, fmt.Sprintf(`cp "target/$TARGET/release/%s$EXT" "dist/%s-$TARGET$EXT"`, bin, bin))
+	}
+	return actions.Job{
+		Name:    "release-rust",
+		RunsOn:  actions.HostedRunner("${{ matrix.os }}"),
+		Timeout: config.JobTimeout,
+		Strategy: &actions.Strategy{
+			Matrix:   actions.Matrix{Include: include},
+			FailFast: actions.Bool(false),
+		},
+		Steps: []actions.Step{
+			actions.MakeCheckoutStep(),
+			{
+				Name: "Install stable toolchain",
+				Uses: actions.RustToolchain.Ref(),
+				With: map[string]string{
+					"toolchain": "stable",
+					"target":    "${{ matrix.target }}",
+					"override":  "true",
+				},
+			},
+			{
+				Name: "Install cross",
+				If:   "matrix.cargo == 'cross'",
+				Run:  "cargo install cross --locked",
+			},
+			{
+				Name:  "Build binaries",
+				Shell: "bash",
+				Run:   "${{ matrix.cargo }} build --release --locked --target ${{ matrix.target }}",
+			},
+			{
+				Name:  "Collect binaries",
+				Shell: "bash",
+				Run:   strings.Join(copyCommands, "\n"),
+				Env: map[string]string{
+					"TARGET": "${{ matrix.target }}",
+					"EXT":    "${{ matrix.ext }}",
+				},
+			},
+			makeUploadReleaseArtifactStep("release-rust-${{ matrix.target }}"),
+		},
+	}
+}
+
 func (langRust) Make(_repoRoot string, config config.CiConfig) JobSet {
+	var release []actions.Job
+	if config.Release.Enabled && len(config.Release.RustBinaries) != 0 {
+		release = append(release, makeRustReleaseJob(config))
+	}
 
 	compileCargoUdeps := `
 cargo install cargo-udeps --locked --version %s
@@ -60,17 +143,19 @@ cargo udeps
 `
 
 	return JobSet{
+		Source:  "Cargo.toml",
+		Paths:   []string{"**/*.rs", "**/Cargo.toml", "Cargo.lock", "rust-toolchain*", "rustfmt.toml", "deny.toml", ".cargo/**"},
+		Release: release,
 		CI: []actions.Job{
 			{
 				Name:    "rustfmt",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					makeInstallTooclhainStep("nightly"),
 					{
 						Name: "Check formatting",
-						Uses: "actions-rs/cargo@v1",
+						Uses: actions.RustCargo.Ref(),
 						With: map[string]string{
 							"command": "fmt",
 							"args":    "-- --check",
@@ -80,14 +165,13 @@ cargo udeps
 			},
 			{
 				Name:    "rust-unit-tests",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					makeRustCacheStep(),
 					{
 						Name: "Run unit tests",
-						Uses: "actions-rs/cargo@v1",
+						Uses: actions.RustCargo.Ref(),
 						With: map[string]string{
 							"command": "test",
 						},
@@ -96,7 +180,6 @@ cargo udeps
 			},
 			{
 				Name:    "rust-unused-deps",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
@@ -105,7 +188,7 @@ cargo udeps
 					{
 						Name: "Fetch prebuilt cargo-udeps",
 						Id:   "cargo_udeps",
-						Uses: "actions/cache@v2",
+						Uses: actions.Cache.Ref(),
 						With: map[string]string{
 							"path": "~/udeps",
 							"key":  fmt.Sprintf("udeps-bin-${{ runner.os }}-v%s", CargoUdepsVersion),
@@ -124,13 +207,12 @@ cargo udeps
 			},
 			{
 				Name:    "rust-cargo-deny",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					{
 						Name: "Run cargo-deny",
-						Uses: "EmbarkStudios/cargo-deny-action@v1",
+						Uses: actions.CargoDeny.Ref(),
 						With: map[string]string{
 							"command": "check all",
 						},
@@ -139,13 +221,12 @@ cargo udeps
 			},
 			{
 				Name:    "rust-lint",
-				RunsOn:  actions.UbuntuRunner,
 				Timeout: config.JobTimeout,
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					{
 						Name: "Run clippy",
-						Uses: "actions-rs/cargo@v1",
+						Uses: actions.RustCargo.Ref(),
 						With: map[string]string{
 							"command": "clippy",
 							"args":    "--workspace -- -Dwarnings",
@@ -157,11 +238,8 @@ cargo udeps
 	}
 }
 
-func (langRust) WriteAdditionalFiles(repoRoot string) error {
-	rustfmtConfig := `
-# GENERATED FILE
-
-imports_granularity = "Crate"
+func (langRust) AdditionalFiles(repoRoot string) (map[string][]byte, error) {
+	rustfmtConfig := `imports_granularity = "Crate"
 force_explicit_abi = true
 reorder_imports = true
 reorder_modules = true
@@ -176,9 +254,7 @@ unstable_features = true
 version = "Two"
 `
 
-	rustfmtCfgPath := path.Join(repoRoot, "rustfmt.toml")
-
-	return os.WriteFile(rustfmtCfgPath, []byte(rustfmtConfig), 0o755)
+	return map[string][]byte{"rustfmt.toml": []byte(rustfmtConfig)}, nil
 }
 
 func makeLanguageForRust() Language {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+)
+
+func findWorkflowFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+	for _, ext := range []string{"yaml", "yml"} {
+		matches, err := filepath.Glob(filepath.Join(root, ".github/workflows", "*."+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func runLintWorkflows(args []string) {
+	flags := flag.NewFlagSet("lint-workflows", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository to lint workflows of")
+	warningsAsErrors := flags.Bool("strict", false, "fail if warnings are reported")
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+
+	files, err := findWorkflowFiles(*repoRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rel, _ := filepath.Rel(*repoRoot, file)
+		w, err := actions.ParseWorkflow(data)
+		if err != nil {
+			fmt.Printf("%s: failed to parse: %v\n", rel, err)
+			failed = true
+			continue
+		}
+		for _, f := range w.Lint() {
+			fmt.Printf("%s: %v\n", rel, f)
+			if f.Severity == actions.SeverityError || *warningsAsErrors {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	log.Printf("checked %d workflows", len(files))
+}
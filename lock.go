@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/fileset"
+)
+
+// shaSource resolves action tags to commit SHAs without network access.
+type shaSource interface {
+	resolve(a actions.Action) (string, error)
+}
+
+// dataFileSource reads SHAs from a file with lines `owner/repo@tag sha`.
+type dataFileSource map[string]string
+
+func loadDataFile(p string) (dataFileSource, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src := make(dataFileSource)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected `owner/repo@tag sha`", p, lineNo)
+		}
+		src[fields[0]] = fields[1]
+	}
+	return src, scanner.Err()
+}
+
+func (s dataFileSource) resolve(a actions.Action) (string, error) {
+	sha, ok := s[a.Ref()]
+	if !ok {
+		return "", fmt.Errorf("%s not listed in data file", a.Ref())
+	}
+	if !actions.IsCommitSha(sha) {
+		return "", fmt.Errorf("invalid sha %q for %s", sha, a.Ref())
+	}
+	return sha, nil
+}
+
+// mirrorSource resolves tags using local git mirrors laid out as
+// `<dir>/<owner>/<repo>` or `<dir>/<owner>/<repo>.git`.
+type mirrorSource string
+
+func (s mirrorSource) resolve(a actions.Action) (string, error) {
+	// action may live in a subdirectory of the repository
+	repoParts := strings.SplitN(a.Repo, "/", 3)
+	if len(repoParts) < 2 {
+		return "", fmt.Errorf("invalid action repository %s", a.Repo)
+	}
+	repo := path.Join(repoParts[0], repoParts[1])
+	var lastErr error
+	for _, dir := range []string{path.Join(string(s), repo), path.Join(string(s), repo+".git")} {
+		if _, err := os.Stat(dir); err != nil {
+			lastErr = err
+			continue
+		}
+		cmd := exec.Command("git", "-C", dir, "rev-parse", "--verify", a.Tag+"^{commit}")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git rev-parse failed for %s: %v: %s", a.Ref(), err, stderr.String())
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", fmt.Errorf("mirror for %s not found: %w", a.Repo, lastErr)
+}
+
+// usedActions returns third-party actions referenced by steps of workflows,
+// sorted by reference. Actions already pinned to a commit are skipped.
+func usedActions(workflows []actions.Workflow) []actions.Action {
+	seen := make(map[actions.Action]bool)
+	res := make([]actions.Action, 0)
+	for _, w := range workflows {
+		for _, job := range w.Jobs {
+			for _, step := range job.Steps {
+				a, ok := actions.ParseActionRef(step.Uses)
+				if !ok || actions.IsCommitSha(a.Tag) || seen[a] {
+					continue
+				}
+				seen[a] = true
+				res = append(res, a)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Ref() < res[j].Ref()
+	})
+	return res
+}
+
+func runLock(args []string) {
+	flags := flag.NewFlagSet("lock", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository")
+	mirror := flags.String("mirror", "", "directory with git mirrors of action repositories")
+	dataFile := flags.String("data", "", "file with lines `owner/repo@tag sha`")
+
+	_ = flags.Parse(args)
+	if *repoRoot == "" {
+		log.Fatal("--repo-root not provided")
+	}
+	var src shaSource
+	switch {
+	case *mirror != "" && *dataFile != "":
+		log.Fatal("only one of --mirror and --data can be provided")
+	case *mirror != "":
+		src = mirrorSource(*mirror)
+	case *dataFile != "":
+		data, err := loadDataFile(*dataFile)
+		if err != nil {
+			log.Fatalf("failed to load data file: %v", err)
+		}
+		src = data
+	default:
+		log.Fatal("one of --mirror and --data must be provided")
+	}
+
+	lockPath := path.Join(*repoRoot, config.ActionsLockPath)
+	oldLock, err := actions.LoadLock(lockPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.Load(*repoRoot)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	// workflows are generated only to find used actions, so missing pins are
+	// expected
+	cfg.RequirePinnedActions = false
+	// actions which were locked manually are refreshed too
+	toLock := usedActions(generateWithConfig(*repoRoot, cfg).workflows)
+	known := make(map[actions.Action]bool)
+	for _, a := range toLock {
+		known[a] = true
+	}
+	for _, locked := range oldLock.Actions {
+		a := actions.Action{Repo: locked.Repo, Tag: locked.Tag}
+		if !known[a] {
+			toLock = append(toLock, a)
+		}
+	}
+
+	newLock := actions.Lock{}
+	failed := make([]string, 0)
+	for _, a := range toLock {
+		sha, err := src.resolve(a)
+		if err != nil {
+			if prev, ok := oldLock.Lookup(a); ok {
+				log.Printf("warning: keeping previous sha for %s: %v", a.Ref(), err)
+				sha = prev
+			} else {
+				log.Printf("error: %v", err)
+				failed = append(failed, a.Ref())
+				continue
+			}
+		}
+		newLock.Actions = append(newLock.Actions, actions.LockedAction{
+			Repo: a.Repo,
+			Tag:  a.Tag,
+			Sha:  sha,
+		})
+	}
+	if len(failed) != 0 {
+		log.Fatalf("failed to resolve: %v", failed)
+	}
+	data, err := newLock.Serialize()
+	if err != nil {
+		log.Fatal(err)
+	}
+	files := fileset.New()
+	if err := files.Add(config.ActionsLockPath, data); err != nil {
+		log.Fatal(err)
+	}
+	if err := files.Write(*repoRoot); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("locked %d actions", len(newLock.Actions))
+}
@@ -6,46 +6,104 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/jjs-dev/ci-config-gen/actions"
 	"github.com/jjs-dev/ci-config-gen/bors"
 	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/gating"
 	"github.com/jjs-dev/ci-config-gen/languages"
-	"gopkg.in/yaml.v2"
 )
 
-func preprocessWorkflow(workflow actions.Workflow) actions.Workflow {
+// ciBranches returns branches pushes to which trigger CI: bors uses staging
+// and trying branches, and master receives merged changes. Merge queue tests
+// candidates on merge_group events instead.
+func ciBranches(cfg config.CiConfig) []string {
+	if cfg.MergeGating == config.GatingMergeQueue {
+		return []string{cfg.MergeQueue.Branch}
+	}
+	return []string{"staging", "trying", "master"}
+}
+
+func preprocessWorkflow(workflow actions.Workflow, lock actions.Lock, cfg config.CiConfig) actions.Workflow {
 	err := workflow.Validate()
 	if err != nil {
 		log.Fatalf("Workflow %s in invalid: %v", workflow.Name, err)
 	}
-	// no modifications currently
+	workflow, unpinned := lock.Pin(workflow)
+	if len(unpinned) != 0 {
+		if cfg.RequirePinnedActions {
+			log.Fatalf("Workflow %s uses actions missing from %s: %v", workflow.Name, config.ActionsLockPath, unpinned)
+		}
+		log.Printf("warning: workflow %s uses unpinned actions: %v", workflow.Name, unpinned)
+	}
 	return workflow
 }
 
-func emitFile(out string, relName string, data []byte) {
-	fullPath := path.Join(out, relName)
-	err := os.WriteFile(fullPath, data, 0o755)
+func writeWorkflow(files *generatedFiles, workflow actions.Workflow, lock actions.Lock, cfg config.CiConfig) {
+	y, err := preprocessWorkflow(workflow, lock, cfg).Marshal()
 	if err != nil {
-		log.Fatalf("failed to write %s: %v", relName, err)
+		log.Fatalf("failed to serialize workflow %v", err)
 	}
+	data := lock.Annotate(y)
+	files.add(fmt.Sprintf(".github/workflows/%s.yaml", workflow.Name), data)
 }
 
-func writeWorkflow(out string, workflow actions.Workflow) {
-	alert := "# GENERATED FILE DO NOT EDIT\n"
-	y, err := yaml.Marshal(preprocessWorkflow(workflow))
-	data := append([]byte(alert), y...)
-	if err != nil {
-		log.Fatalf("failed to serialize workflow %v", err)
-	}
-	emitFile(out, fmt.Sprintf(".github/workflows/%s.yaml", workflow.Name), data)
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command] [flags]
+
+Commands:
+  generate        generate CI configuration (default)
+  init            create ci/config.yaml from repository inspection
+  lock            pin actions used by generated workflows to commit SHAs
+  lint-workflows  check workflows under .github/workflows for security problems
+  check-edits     report generated files which were edited by hand
+  explain         show why each job was generated
+  import-workflows
+                  propose ci/config.yaml reproducing hand-written workflows
+
+Run '%s <command> --help' to see command flags.
+`, os.Args[0], os.Args[0])
 }
 
 func main() {
-	repoRoot := flag.String("repo-root", "", "path to root directory of the repository to generate config for")
-	out := flag.String("output", "", "directory which will contain generated workflow files. defaults to $(repo-root)")
+	command := "generate"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+	switch command {
+	case "generate":
+		runGenerate(args)
+	case "lock":
+		runLock(args)
+	case "lint-workflows":
+		runLintWorkflows(args)
+	case "check-edits":
+		runCheckEdits(args)
+	case "explain":
+		runExplain(args)
+	case "init":
+		runInit(args)
+	case "import-workflows":
+		runImportWorkflows(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func runGenerate(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	repoRoot := flags.String("repo-root", "", "path to root directory of the repository to generate config for")
+	out := flags.String("output", "", "directory which will contain generated workflow files. defaults to $(repo-root)")
+	force := flags.Bool("force", false, "overwrite files not owned by the generator and hand-edited files")
+	adopt := flags.Bool("adopt", false, "convert hand edits of workflows into overrides in "+config.ConfigPath)
 
-	flag.Parse()
+	_ = flags.Parse(args)
 	if *repoRoot == "" {
 		log.Fatal("--repo-root not provided")
 	}
@@ -54,49 +112,199 @@ func main() {
 		*out = *repoRoot
 	}
 
-	config, err := config.Load(*repoRoot)
+	files := generate(*repoRoot).files
+	edits, err := findHandEdits(*out, files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// config with adopted edits, written together with generated files
+	var adoptedConfig []byte
+	if *adopt && len(edits) != 0 {
+		if *out != *repoRoot {
+			log.Fatal("--adopt can not be used with --output, because config must be written along with workflows")
+		}
+		var adopted []string
+		adopted, adoptedConfig, err = adoptEdits(*repoRoot, edits)
+		if err != nil {
+			log.Fatalf("failed to adopt hand edits: %v", err)
+		}
+		if len(adopted) != 0 {
+			log.Printf("adopted hand edits of %v, regenerating", adopted)
+			cfg, err := config.Parse(adoptedConfig)
+			if err != nil {
+				log.Fatalf("config with adopted edits is invalid: %v", err)
+			}
+			files = generateWithConfig(*repoRoot, cfg).files
+			edits, err = findHandEdits(*out, files)
+			if err != nil {
+				log.Fatal(err)
+			}
+			edits = withoutFiles(edits, adopted)
+		}
+	}
+	if len(edits) != 0 {
+		reportHandEdits(edits)
+		if !*force {
+			log.Fatal("generated files were edited by hand, move the changes to " + config.ConfigPath + " or use --force to discard them")
+		}
+	}
+	if adoptedConfig != nil {
+		files.addUserFile(config.ConfigPath, adoptedConfig)
+	}
+	if err := files.commit(*out, *force); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generation is the result of generator run.
+type generation struct {
+	files     *generatedFiles
+	workflows []actions.Workflow
+	gate      gating.Gate
+	overrides *overrideSet
+}
+
+// generate produces all files for the repository in memory.
+func generate(repoRoot string) generation {
+	cfg, err := config.Load(repoRoot)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
-	log.Printf("loaded config: %+v", config)
+	return generateWithConfig(repoRoot, cfg)
+}
+
+// generateWithConfig is generate with config which is not written yet.
+func generateWithConfig(repoRoot string, cfg config.CiConfig) generation {
+	log.Printf("loaded config: %+v", cfg)
+
+	lock, err := actions.LoadLock(path.Join(repoRoot, config.ActionsLockPath))
+	if err != nil {
+		log.Fatalf("failed to load actions lock: %v", err)
+	}
 
-	borsConfig := &bors.BorsConfig{}
-	borsConfig.ApplyDefaults()
-	borsConfig.Timeout = config.BuildTimeout * 60
+	gate := makeGate(cfg)
+	files := newGeneratedFiles()
+	// all generated workflows, used to validate required checks
+	workflows := make([]actions.Workflow, 0)
 
-	metaWorkflow := makeMetaWorkflow(borsConfig, config)
-	writeWorkflow(*out, metaWorkflow)
+	overrides := newOverrideSet(cfg.Overrides)
+
+	metaWorkflow, err := overrides.apply(makeMetaWorkflow(gate, cfg), gate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeWorkflow(files, metaWorkflow, lock, cfg)
+	workflows = append(workflows, metaWorkflow)
 
 	langs := languages.MakeLanguages()
 
 	for _, lang := range langs {
+		if !lang.Used(repoRoot) {
+			continue
+		}
 		log.Printf("Generating files for lang %s\n", lang.Name())
-		err = lang.WriteAdditionalFiles(*repoRoot)
+		langFiles, err := lang.AdditionalFiles(repoRoot)
 		if err != nil {
-			log.Fatalf("failed to write additional files: %v", err)
+			log.Fatalf("failed to generate additional files: %v", err)
 		}
+		for name, data := range langFiles {
+			files.add(name, data)
+		}
+	}
+
+	ciWorkflow, err := overrides.apply(makeCiWorkflow(langs, cfg, repoRoot, gate), gate)
+	if err != nil {
+		log.Fatal(err)
 	}
+	writeWorkflow(files, ciWorkflow, lock, cfg)
+	workflows = append(workflows, ciWorkflow)
 
-	ciWorkflow := makeCiWorkflow(langs, config, *repoRoot, borsConfig)
-	writeWorkflow(*out, ciWorkflow)
+	if cfg.Release.Enabled {
+		log.Println("Generating release workflow")
+		releaseWorkflow, ok := makeReleaseWorkflow(langs, cfg, repoRoot)
+		if !ok {
+			log.Fatal("release enabled, but no release binaries can be built")
+		}
+		releaseWorkflow, err = overrides.apply(releaseWorkflow, gate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeWorkflow(files, releaseWorkflow, lock, cfg)
+		workflows = append(workflows, releaseWorkflow)
+	}
 
-	if !config.NoPublish {
+	if !cfg.NoPublish {
 		log.Println("Generating publish workflow")
-		publishWorkflow := makePublishWorkflow(*repoRoot, config, borsConfig)
-		writeWorkflow(*out, publishWorkflow)
-		script := generatePublishImageScript(config)
-		emitFile(*out, "ci/publish-images.sh", []byte(script))
+		publishWorkflow, err := overrides.apply(makePublishWorkflow(repoRoot, cfg, gate), gate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeWorkflow(files, publishWorkflow, lock, cfg)
+		workflows = append(workflows, publishWorkflow)
+		if len(cfg.DockerImages) != 0 {
+			script := generatePublishImageScript(cfg)
+			files.add("ci/publish-images.sh", []byte(script))
+		}
+	}
+	if err := overrides.checkAllUsed(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Generating %s config", cfg.MergeGating)
+	if err := gate.Resolve(workflows); err != nil {
+		log.Fatalf("invalid %s config: %v", cfg.MergeGating, err)
 	}
-	log.Println("Generating bors config")
-	borsConfigBytes, err := borsConfig.Serialize()
+	if err := newPreflight(repoRoot, files).check(workflows); err != nil {
+		log.Fatal(err)
+	}
+	gateFiles, err := gate.Files()
 	if err != nil {
 		log.Fatal(err)
 	}
-	emitFile(*out, "bors.toml", borsConfigBytes)
+	for name, data := range gateFiles {
+		files.add(name, data)
+	}
+	return generation{
+		files:     files,
+		workflows: workflows,
+		gate:      gate,
+		overrides: overrides,
+	}
 }
 
-func makeMetaWorkflow(bc *bors.BorsConfig, cfg config.CiConfig) actions.Workflow {
-	bc.AddJob("check-ci-config")
+func makeGate(cfg config.CiConfig) gating.Gate {
+	if cfg.MergeGating == config.GatingMergeQueue {
+		return &gating.MergeQueue{
+			Settings: cfg.MergeQueue,
+			Timeout:  cfg.BuildTimeout,
+		}
+	}
+	return makeBorsConfig(cfg)
+}
+
+func makeBorsConfig(cfg config.CiConfig) *bors.BorsConfig {
+	settings := cfg.Bors
+	bc := &bors.BorsConfig{
+		PrStatus:             settings.PrStatus,
+		BlockLabels:          settings.BlockLabels,
+		RequiredApprovals:    settings.RequiredApprovals,
+		UseSquashMerge:       settings.UseSquashMerge,
+		CutBodyAfter:         settings.CutBodyAfter,
+		UpdateBaseForDeletes: settings.UpdateBaseForDeletes,
+		UseCodeowners:        settings.UseCodeowners,
+	}
+	bc.ApplyDefaults()
+	bc.Timeout = cfg.BuildTimeout * 60
+	if settings.Committer != nil {
+		bc.Committer = &bors.Committer{
+			Name:  settings.Committer.Name,
+			Email: settings.Committer.Email,
+		}
+	}
+	return bc
+}
+
+func makeMetaWorkflow(gate gating.Gate, cfg config.CiConfig) actions.Workflow {
+	gate.AddJob("check-ci-config")
 
 	var fetchGenerator actions.Step
 	var generatorLocation string
@@ -116,8 +324,10 @@ func makeMetaWorkflow(bc *bors.BorsConfig, cfg config.CiConfig) actions.Workflow
 
 	jobs := map[string]actions.Job{
 		"check-ci-config": {
-			RunsOn:  actions.UbuntuRunner,
-			Timeout: 1,
+			Comment:    "Fails if generated CI configuration is out of date or was edited by hand",
+			Provenance: []string{"always generated"},
+			RunsOn:     cfg.Runners.Resolve("", "check-ci-config"),
+			Timeout:    1,
 			Steps: []actions.Step{
 				actions.MakeCheckoutStep(),
 				languages.MakeSetupGoStep(),
@@ -126,6 +336,10 @@ func makeMetaWorkflow(bc *bors.BorsConfig, cfg config.CiConfig) actions.Workflow
 					Run:  fmt.Sprintf("cd %s && go install -v .", generatorLocation),
 					Name: "Install ci-config-gen",
 				},
+				{
+					Name: "Detect hand edits of generated files",
+					Run:  "ci-config-gen check-edits --repo-root .",
+				},
 				{
 					Run:  "ci-config-gen --repo-root .",
 					Name: "Run co-config-gen",
@@ -140,8 +354,9 @@ func makeMetaWorkflow(bc *bors.BorsConfig, cfg config.CiConfig) actions.Workflow
 
 	if cfg.Codegen {
 		jobs["check-codegen"] = actions.Job{
-			RunsOn:  actions.UbuntuRunner,
-			Timeout: cfg.JobTimeout,
+			Provenance: []string{"codegen: true"},
+			RunsOn:     cfg.Runners.Resolve("", "check-codegen"),
+			Timeout:    cfg.JobTimeout,
 			Steps: []actions.Step{
 				actions.MakeCheckoutStep(),
 				{
@@ -156,20 +371,179 @@ func makeMetaWorkflow(bc *bors.BorsConfig, cfg config.CiConfig) actions.Workflow
 		}
 	}
 
-	return actions.Workflow{
+	w := actions.Workflow{
 		Name: "meta",
 		On: actions.Trigger{
-			PullRequest: actions.EmptyStruct{},
-			Push: actions.PushTrigger{
-				Branches: []string{"staging", "trying", "master"},
+			PullRequest: &actions.PullRequestTrigger{},
+			Push: &actions.PushTrigger{
+				Branches: ciBranches(cfg),
 			},
 		},
-		Jobs: jobs,
+		Permissions: actions.ReadOnlyPermissions(),
+		Jobs:        jobs,
 	}
+	gate.PatchTrigger(&w.On)
+	return w
+}
 
+const (
+	kindClusterName = "e2e"
+	e2eImagesPath   = "e2e-artifacts/e2e-images.tar"
+)
+
+// collectClusterLogsScript dumps state of the kind cluster into e2e-logs.
+const collectClusterLogsScript = `mkdir -p e2e-logs/cluster
+kubectl get events --all-namespaces --sort-by=.lastTimestamp > e2e-logs/cluster/events.txt || true
+kubectl get pods --all-namespaces -o wide > e2e-logs/cluster/pods.txt || true
+kubectl get pods --all-namespaces -o jsonpath='{range .items[*]}{.metadata.namespace} {.metadata.name}{"\n"}{end}' |
+while read -r ns pod
+do
+  kubectl logs --namespace "$ns" "$pod" --all-containers --prefix > "e2e-logs/cluster/$ns-$pod.log" 2>&1 || true
+done
+kind export logs e2e-logs/cluster/kind --name %s || true`
+
+// e2eImageRef returns tag of the image as loaded into kind cluster.
+func e2eImageRef(img config.DockerImage) string {
+	return img.Name + ":e2e"
+}
+
+// makeE2eImageSteps builds images in e2e-build job and saves them into e2e
+// artifacts.
+func makeE2eImageSteps(cfg config.CiConfig) []actions.Step {
+	steps := make([]actions.Step, 0)
+	refs := make([]string, 0, len(cfg.DockerImages))
+	for _, img := range cfg.DockerImages {
+		refs = append(refs, e2eImageRef(img))
+		if img.Context == "" {
+			continue
+		}
+		args := []string{"docker", "build", "--tag", e2eImageRef(img), "--file", img.DockerfilePath()}
+		buildArgNames := make([]string, 0, len(img.BuildArgs))
+		for name := range img.BuildArgs {
+			buildArgNames = append(buildArgNames, name)
+		}
+		sort.Strings(buildArgNames)
+		for _, name := range buildArgNames {
+			args = append(args, "--build-arg", fmt.Sprintf("%q", name+"="+img.BuildArgs[name]))
+		}
+		args = append(args, img.Context)
+		steps = append(steps, actions.Step{
+			Name: fmt.Sprintf("Build image %s", img.Name),
+			Run:  strings.Join(args, " "),
+		})
+	}
+	return append(steps, actions.Step{
+		Name: "Save images",
+		Run:  fmt.Sprintf("mkdir -p e2e-artifacts\ndocker save --output %s %s", e2eImagesPath, strings.Join(refs, " ")),
+	})
+}
+
+// makeKindSetupSteps creates kind cluster, load images into it and install
+// Helm chart.
+func makeKindSetupSteps(cfg config.CiConfig) []actions.Step {
+	kind := cfg.E2e.Kind
+	createCluster := actions.Step{
+		Name: "Create kind cluster",
+		Uses: actions.KindCluster.Ref(),
+		With: map[string]string{
+			"cluster_name": kindClusterName,
+		},
+	}
+	if kind.NodeImage != "" {
+		createCluster.With["node_image"] = kind.NodeImage
+	}
+	load := fmt.Sprintf("docker load --input %s", e2eImagesPath)
+	for _, img := range cfg.DockerImages {
+		load += fmt.Sprintf("\nkind load docker-image --name %s %s", kindClusterName, e2eImageRef(img))
+	}
+	steps := []actions.Step{
+		createCluster,
+		{
+			Name: "Load images into cluster",
+			Run:  load,
+		},
+	}
+	if kind.Chart != "" {
+		install := fmt.Sprintf("helm install %s %s --wait --timeout %dm", kind.Release, kind.Chart, cfg.JobTimeout)
+		if kind.ChartValues != "" {
+			install += " --values " + kind.ChartValues
+		}
+		steps = append(steps, actions.Step{
+			Name: "Install Helm",
+			Uses: actions.SetupHelm.Ref(),
+		}, actions.Step{
+			Name: "Install Helm chart",
+			Run:  install,
+		})
+	}
+	return steps
 }
 
-func makeCiE2eJob(root string, config config.CiConfig, languages []languages.Language) (actions.Job, actions.Job) {
+// makeE2eRunJob creates job running e2e suite on artifacts of e2e-build. Its
+// logs are uploaded under logsName.
+func makeE2eRunJob(cfg config.CiConfig, jobName string, suite config.E2eSuite, logsName string) actions.Job {
+	job := actions.Job{
+		RunsOn:   cfg.Runners.Resolve("", jobName),
+		Needs:    actions.StringList{"e2e-build"},
+		Timeout:  cfg.JobTimeout,
+		Services: suite.Services,
+	}
+	if shards := suite.Shards; shards > 1 {
+		shardValues := make([]string, 0, shards)
+		for i := 1; i <= shards; i++ {
+			shardValues = append(shardValues, strconv.Itoa(i))
+		}
+		job.Strategy = &actions.Strategy{
+			Matrix:   actions.Matrix{Values: map[string][]string{"shard": shardValues}},
+			FailFast: actions.Bool(false),
+		}
+		job.Env = map[string]string{
+			"E2E_SHARD": "${{ matrix.shard }}",
+			"E2E_TOTAL": strconv.Itoa(shards),
+		}
+		logsName += "-${{ matrix.shard }}"
+	}
+	steps := []actions.Step{
+		actions.MakeCheckoutStep(),
+		{
+			Name: "Download e2e artifacts",
+			Uses: actions.DownloadArtifact.Ref(),
+			With: map[string]string{
+				"name": "e2e-artifacts",
+				"path": "e2e-artifacts",
+			},
+		},
+	}
+	if cfg.E2e.Kind != nil {
+		steps = append(steps, makeKindSetupSteps(cfg)...)
+	}
+	steps = append(steps, actions.Step{
+		Name: "Execute tests",
+		Run:  "bash " + suite.RunScript,
+	})
+	if cfg.E2e.Kind != nil {
+		steps = append(steps, actions.Step{
+			Name: "Collect cluster logs",
+			If:   "failure()",
+			Run:  fmt.Sprintf(collectClusterLogsScript, kindClusterName),
+		})
+	}
+	job.Steps = append(steps, actions.Step{
+		Name: "Upload logs",
+		Uses: actions.UploadArtifact.Ref(),
+		If:   "always()",
+		With: map[string]string{
+			"name":           logsName,
+			"path":           "e2e-logs",
+			"retention-days": strconv.Itoa(cfg.E2e.RetentionDays),
+		},
+	})
+	return job
+}
+
+// makeCiE2eJobs creates e2e-build job and run jobs of all suites, keyed by
+// job name.
+func makeCiE2eJobs(root string, config config.CiConfig, languages []languages.Language) map[string]actions.Job {
 	buildSteps := []actions.Step{
 		actions.MakeCheckoutStep(),
 	}
@@ -184,77 +558,95 @@ func makeCiE2eJob(root string, config config.CiConfig, languages []languages.Lan
 	}
 	buildSteps = append(buildSteps, actions.Step{
 		Name: "Build e2e artifacts",
-		Run:  "bash ci/e2e-build.sh",
-	}, actions.Step{
+		Run:  "bash " + config.E2e.BuildScript,
+	})
+	if config.E2e.Kind != nil {
+		buildSteps = append(buildSteps, makeE2eImageSteps(config)...)
+	}
+	buildSteps = append(buildSteps, actions.Step{
 		Name: "Upload e2e artifacts",
-		Uses: "actions/upload-artifact@v2",
+		Uses: actions.UploadArtifact.Ref(),
 		With: map[string]string{
 			"name":           "e2e-artifacts",
 			"path":           "e2e-artifacts",
-			"retention-days": "2",
+			"retention-days": strconv.Itoa(config.E2e.RetentionDays),
 		},
 	})
 
-	build := actions.Job{
-		RunsOn:  actions.UbuntuRunner,
-		Steps:   buildSteps,
-		Timeout: config.JobTimeout,
-		Env: map[string]string{
-			"DOCKER_BUILDKIT": "1",
+	jobs := map[string]actions.Job{
+		"e2e-build": {
+			RunsOn:  config.Runners.Resolve("", "e2e-build"),
+			Steps:   buildSteps,
+			Timeout: config.JobTimeout,
+			Env: map[string]string{
+				"DOCKER_BUILDKIT": "1",
+			},
 		},
 	}
-	run := actions.Job{
-		RunsOn:  actions.UbuntuRunner,
-		Needs:   "e2e-build",
-		Timeout: config.JobTimeout,
+	if len(config.E2e.Suites) == 0 {
+		jobs["e2e-run"] = makeE2eRunJob(config, "e2e-run", config.E2e.DefaultSuite(), "e2e-logs")
+	}
+	for _, suite := range config.E2e.Suites {
+		jobName := "e2e-run-" + suite.Name
+		jobs[jobName] = makeE2eRunJob(config, jobName, suite, "e2e-logs-"+suite.Name)
+	}
+	return jobs
+}
+
+// makeCiSuccessJob creates job which succeeds only if all needed jobs
+// succeeded, so that it can be the only check required for merge.
+func makeCiSuccessJob(cfg config.CiConfig, needs []string) actions.Job {
+	return actions.Job{
+		Comment: "Aggregates results of all required jobs, so that merge rules only need this check",
+		Needs:   needs,
+		// must report failure instead of being skipped when needed jobs fail
+		If:      "always()",
+		RunsOn:  cfg.Runners.Resolve("", "ci-success"),
+		Timeout: 1,
+		Env: map[string]string{
+			"RESULTS": "${{ join(needs.*.result, ' ') }}",
+		},
 		Steps: []actions.Step{
-			actions.MakeCheckoutStep(),
 			{
-				Name: "Download e2e artifacts",
-				Uses: "actions/download-artifact@v2",
-				With: map[string]string{
-					"name": "e2e-artifacts",
-					"path": "e2e-artifacts",
-				},
-			},
-			{
-				Name: "Execute tests",
-				Run:  "bash ci/e2e-run.sh",
-			},
-			{
-				Name: "Upload logs",
-				Uses: "actions/upload-artifact@v2",
-				If:   "always()",
-				With: map[string]string{
-					"name":           "e2e-logs",
-					"path":           "e2e-logs",
-					"retention-days": "2",
-				},
+				Name: "Check results of required jobs",
+				Run: `for result in $RESULTS; do
+  if [ "$result" != success ] && [ "$result" != skipped ]; then
+    echo "required jobs did not succeed: $RESULTS"
+    exit 1
+  fi
+done`,
 			},
 		},
 	}
-
-	return build, run
 }
 
-func makeCiWorkflow(langs []languages.Language, config config.CiConfig, repoRoot string, bc *bors.BorsConfig) actions.Workflow {
+func makeCiWorkflow(langs []languages.Language, config config.CiConfig, repoRoot string, gate gating.Gate) actions.Workflow {
 	w := actions.Workflow{
 		Name: "ci",
 		On: actions.Trigger{
-			PullRequest: actions.EmptyStruct{},
-			Push: actions.PushTrigger{
-				Branches: []string{"staging", "trying", "master"},
+			PullRequest: &actions.PullRequestTrigger{},
+			Push: &actions.PushTrigger{
+				Branches: ciBranches(config),
 			},
 		},
+		Permissions: actions.ReadOnlyPermissions(),
 		Jobs: map[string]actions.Job{
 			"misspell": {
-				RunsOn:  actions.UbuntuRunner,
-				Timeout: 2,
+				Comment:    "Reports misspelled words as review comments",
+				Provenance: []string{"always generated"},
+				RunsOn:     config.Runners.Resolve("", "misspell"),
+				Timeout:    2,
+				// reviewdog reports findings as checks and review comments
+				Permissions: actions.Permissions{
+					"contents":      actions.PermissionRead,
+					"checks":        actions.PermissionWrite,
+					"pull-requests": actions.PermissionWrite,
+				},
 				Steps: []actions.Step{
 					actions.MakeCheckoutStep(),
 					{
 						Name: "run spellcheck",
-						Uses: "reviewdog/action-misspell@v1",
+						Uses: actions.Misspell.Ref(),
 						With: map[string]string{
 							"github_token": "${{ secrets.GITHUB_TOKEN }}",
 							"locale":       "US",
@@ -266,28 +658,104 @@ func makeCiWorkflow(langs []languages.Language, config config.CiConfig, repoRoot
 	}
 
 	perLanguageJobs := make([]languages.JobSet, 0)
+	// path filters by language name, and languages of jobs
+	filters := make(map[string][]string)
+	jobFilters := make(map[string]string)
+	// e2e tests depend on all languages, so they can be filtered only if
+	// every language is
+	e2eFiltered := true
 
 	for _, lang := range langs {
 		if lang.Used(repoRoot) {
 			log.Printf("Generating %s CI jobs", lang.Name())
-			perLanguageJobs = append(perLanguageJobs, lang.Make(repoRoot, config))
+			js := lang.Make(repoRoot, config)
+			for i := range js.CI {
+				js.CI[i].RunsOn = config.Runners.Resolve(lang.Name(), js.CI[i].Name)
+				js.CI[i].Provenance = append(js.CI[i].Provenance, fmt.Sprintf("language %s detected by %s", lang.Name(), js.Source))
+				if len(js.Paths) != 0 {
+					jobFilters[js.CI[i].Name] = lang.Name()
+				}
+			}
+			if len(js.Paths) != 0 {
+				filters[lang.Name()] = js.Paths
+			} else {
+				e2eFiltered = false
+			}
+			perLanguageJobs = append(perLanguageJobs, js)
 		}
 	}
 
+	// keys of jobs which must pass before merge
+	required := []string{"misspell"}
+
 	if !config.NoE2e {
-		e2eBuild, e2eRun := makeCiE2eJob(repoRoot, config, langs)
-		bc.AddJob("e2e-build")
-		bc.AddJob("e2e-run")
-		w.Jobs["e2e-build"] = e2eBuild
-		w.Jobs["e2e-run"] = e2eRun
+		e2eJobs := makeCiE2eJobs(repoRoot, config, langs)
+		for _, jobName := range actions.OrderJobs(e2eJobs) {
+			job := e2eJobs[jobName]
+			job.Provenance = []string{"noE2e is not set"}
+			required = append(required, jobName)
+			w.Jobs[jobName] = job
+			if e2eFiltered && len(filters) != 0 {
+				jobFilters[jobName] = e2eFilterName
+			}
+		}
+		if e2eFiltered && len(filters) != 0 {
+			filters[e2eFilterName] = e2ePaths(config, filters)
+		}
 	}
 
 	for _, js := range perLanguageJobs {
 		for _, job := range js.CI {
-			bc.AddJob(job.Name)
+			required = append(required, job.Name)
 			w.Jobs[job.Name] = job
 		}
 	}
 
+	if config.PathFilters && len(filters) != 0 {
+		applyPathFilters(w.Jobs, jobFilters)
+		w.Jobs[changesJobName] = makeChangesJob(config, filters)
+		required = append(required, changesJobName)
+	}
+
+	customJobNames := make([]string, 0, len(config.CustomJobs))
+	for jobName := range config.CustomJobs {
+		customJobNames = append(customJobNames, jobName)
+	}
+	sort.Strings(customJobNames)
+	for _, jobName := range customJobNames {
+		if _, ok := w.Jobs[jobName]; ok {
+			log.Fatalf("custom job %s clashes with generated job", jobName)
+		}
+		job := config.CustomJobs[jobName]
+		if job.Uses == "" {
+			if job.RunsOn.IsZero() {
+				job.RunsOn = config.Runners.Resolve("", jobName)
+			}
+			if job.Timeout == 0 {
+				job.Timeout = config.JobTimeout
+			}
+		}
+		job.Provenance = []string{fmt.Sprintf("customJobs.%s", jobName)}
+		w.Jobs[jobName] = job
+		// check names of reusable workflow jobs depend on the called workflow
+		if job.Uses != "" && !config.AggregateChecks {
+			log.Printf("warning: custom job %s calls reusable workflow, so it is not a required check", jobName)
+			continue
+		}
+		required = append(required, jobName)
+	}
+
+	if config.AggregateChecks {
+		success := makeCiSuccessJob(config, required)
+		success.Provenance = []string{"aggregateChecks: true"}
+		w.Jobs["ci-success"] = success
+		gate.AddJob("ci-success")
+	} else {
+		for _, jobName := range required {
+			gate.AddJob(jobName)
+		}
+	}
+
+	gate.PatchTrigger(&w.On)
 	return w
 }
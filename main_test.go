@@ -1,13 +1,30 @@
 package main
 
 import (
+	"os"
+	"path"
+	"strings"
 	"testing"
 
+	"github.com/jjs-dev/ci-config-gen/actions"
 	"github.com/jjs-dev/ci-config-gen/bors"
 	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/languages"
+	"gopkg.in/yaml.v2"
 	"gotest.tools/v3/assert"
 )
 
+// makeRepo creates temporary repository with given files, keyed by path
+// relative to its root.
+func makeRepo(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		assert.NilError(t, os.MkdirAll(path.Dir(path.Join(root, name)), 0o755))
+		assert.NilError(t, os.WriteFile(path.Join(root, name), []byte(data), 0o644))
+	}
+	return root
+}
+
 func TestMetaWorkflowValid(t *testing.T) {
 	cfg := config.CiConfig{
 		Codegen:    true,
@@ -17,3 +34,686 @@ func TestMetaWorkflowValid(t *testing.T) {
 	err := meta.Validate()
 	assert.NilError(t, err)
 }
+
+func TestOverrideInsertsSteps(t *testing.T) {
+	job := actions.Job{
+		Steps: []actions.Step{{Name: "a"}, {Name: "b"}},
+	}
+	o := config.JobOverride{
+		Timeout: 30,
+		InsertSteps: []config.StepInsertion{
+			{Before: "a", Steps: []actions.Step{{Name: "before-a"}}},
+			{After: "b", Steps: []actions.Step{{Name: "after-b"}}},
+		},
+	}
+	patched, err := applyOverride(job, o)
+	assert.NilError(t, err)
+	assert.Equal(t, patched.Timeout, 30)
+	names := make([]string, 0)
+	for _, step := range patched.Steps {
+		names = append(names, step.Name)
+	}
+	assert.DeepEqual(t, names, []string{"before-a", "a", "b", "after-b"})
+
+	_, err = applyOverride(job, config.JobOverride{
+		InsertSteps: []config.StepInsertion{{After: "missing", Steps: []actions.Step{{Name: "x"}}}},
+	})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestOverrideUnknownJob(t *testing.T) {
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Overrides: map[string]config.JobOverride{
+			"check-ci-config": {Timeout: 5},
+			"no-such-job":     {Disabled: true},
+		},
+	}
+	bc := &bors.BorsConfig{}
+	s := newOverrideSet(cfg.Overrides)
+	meta, err := s.apply(makeMetaWorkflow(bc, cfg), bc)
+	assert.NilError(t, err)
+	assert.Equal(t, meta.Jobs["check-ci-config"].Timeout, 5)
+	assert.ErrorContains(t, s.checkAllUsed(), "no-such-job")
+}
+
+func TestDisabledJobNeededByOthers(t *testing.T) {
+	w := actions.Workflow{
+		Jobs: map[string]actions.Job{
+			"e2e-build":  {},
+			"e2e-run":    {Needs: actions.StringList{"e2e-build"}},
+			"ci-success": {Needs: actions.StringList{"e2e-build", "e2e-run"}},
+		},
+	}
+	bc := &bors.BorsConfig{}
+
+	s := newOverrideSet(map[string]config.JobOverride{"e2e-build": {Disabled: true}})
+	_, err := s.apply(w, bc)
+	assert.ErrorContains(t, err, "e2e-run needs disabled job e2e-build")
+
+	s = newOverrideSet(map[string]config.JobOverride{
+		"e2e-build": {Disabled: true},
+		"e2e-run":   {Disabled: true},
+	})
+	patched, err := s.apply(w, bc)
+	assert.NilError(t, err)
+	assert.Equal(t, len(patched.Jobs), 1)
+	assert.Equal(t, len(patched.Jobs["ci-success"].Needs), 0)
+}
+
+func TestPublishScriptUsesRegistries(t *testing.T) {
+	cfg := config.CiConfig{
+		Registries: map[string]config.Registry{
+			"ghcr":      {Host: "ghcr.io", Namespace: "fork"},
+			"dockerhub": {Host: "docker.io", UsernameSecret: "HUB_USER", PasswordSecret: "HUB_TOKEN"},
+		},
+		DockerImages: []config.DockerImage{
+			{Name: "app", Registries: []string{"ghcr", "dockerhub"}, Repository: "org/app"},
+			{Name: "tool", Registries: []string{"ghcr"}},
+		},
+	}
+	script := generatePublishImageScript(cfg)
+	assert.Assert(t, strings.Contains(script, `docker login docker.io -u "$REGISTRY_DOCKERHUB_USERNAME"`))
+	assert.Assert(t, strings.Contains(script, "docker push docker.io/org/app:$TAG"))
+	assert.Assert(t, strings.Contains(script, "docker push ghcr.io/fork/tool:$TAG"))
+}
+
+func TestDuplicateDockerImagesRejected(t *testing.T) {
+	_, err := config.Parse([]byte("buildTimeoutMinutes: 5\nnoE2e: true\ndockerImages:\n  - name: api\n  - name: api\n"))
+	assert.ErrorContains(t, err, "duplicate docker image api")
+	for _, pair := range [][2]string{{"my-app", "my_app"}, {"App", "app"}} {
+		_, err = config.Parse([]byte("buildTimeoutMinutes: 5\nnoE2e: true\ndockerImages:\n  - name: " + pair[0] + "\n  - name: " + pair[1] + "\n"))
+		assert.ErrorContains(t, err, "differ only in case or punctuation")
+	}
+}
+
+func TestPublishTriggerFollowsTaggingPolicy(t *testing.T) {
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Tagging: config.TaggingPolicy{
+			Branches: map[string]string{"master": "latest", "release": "stable"},
+			Semver:   true,
+		},
+	}
+	w := makePublishWorkflow(t.TempDir(), cfg, &bors.BorsConfig{})
+	assert.DeepEqual(t, w.On.Push.Branches, []string{"staging", "trying", "master", "release"})
+	assert.DeepEqual(t, w.On.Push.Tags, []string{"v*"})
+	script := generatePublishImageScript(cfg)
+	assert.Assert(t, strings.Contains(script, "refs/heads/staging|refs/heads/trying)\n  exit 0"))
+}
+
+func TestPublishWithMergeQueue(t *testing.T) {
+	cfg, err := config.Parse([]byte("buildTimeoutMinutes: 5\nnoE2e: true\nmergeGating: mergeQueue\ndockerImages: [app]\n"))
+	assert.NilError(t, err)
+	// trying is a bors branch
+	assert.DeepEqual(t, cfg.Tagging.Branches, map[string]string{"master": "latest"})
+	gate := makeGate(cfg)
+	w := makePublishWorkflow(t.TempDir(), cfg, gate)
+	assert.DeepEqual(t, w.On.Push.Branches, []string{"master"})
+	// images are not published for merge candidates, so nothing is gated
+	assert.Assert(t, w.On.MergeGroup == nil)
+	assert.NilError(t, gate.Resolve([]actions.Workflow{w}))
+}
+
+func TestPublishBuildsImagesWithBuildx(t *testing.T) {
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Registries: map[string]config.Registry{
+			"ghcr": {Host: "ghcr.io", Namespace: "jjs-dev"},
+		},
+		DockerImages: []config.DockerImage{
+			{
+				Name:       "app",
+				Context:    "src",
+				Registries: []string{"ghcr"},
+				Platforms:  []string{"linux/amd64", "linux/arm64"},
+				BuildArgs:  map[string]string{"VERSION": "1"},
+			},
+		},
+	}
+	w := makePublishWorkflow(t.TempDir(), cfg, &bors.BorsConfig{})
+	assert.NilError(t, w.Validate())
+	names := make([]string, 0)
+	for _, step := range w.Jobs["publish"].Steps {
+		names = append(names, step.Name)
+	}
+	assert.DeepEqual(t, names, []string{"Fetch sources", "Set up QEMU", "Set up docker buildx", "Publish docker images", "Build and push app", "Write digests summary"})
+	build := w.Jobs["publish"].Steps[4]
+	assert.Equal(t, build.With["platforms"], "linux/amd64,linux/arm64")
+	assert.Equal(t, build.With["file"], "src/Dockerfile")
+	assert.Equal(t, build.With["build-args"], "VERSION=1")
+}
+
+func TestPublishSignsImagesByDigest(t *testing.T) {
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Registries: map[string]config.Registry{
+			"ghcr": {Host: "ghcr.io", Namespace: "jjs-dev"},
+		},
+		DockerImages: []config.DockerImage{
+			{Name: "app", Registries: []string{"ghcr"}, Sign: true, Sbom: true, Provenance: true},
+		},
+	}
+	w := makePublishWorkflow(t.TempDir(), cfg, &bors.BorsConfig{})
+	assert.NilError(t, w.Validate())
+	job := w.Jobs["publish"]
+	assert.Equal(t, job.Outputs["app-digest"], "${{ steps.images.outputs.app_digest }}")
+	assert.Equal(t, job.Permissions["id-token"], actions.PermissionWrite)
+	assert.Equal(t, job.Permissions["attestations"], actions.PermissionWrite)
+	var sign actions.Step
+	for _, step := range job.Steps {
+		if step.Name == "Sign app" {
+			sign = step
+		}
+	}
+	assert.Assert(t, strings.Contains(sign.Run, `cosign sign --yes "ghcr.io/jjs-dev/app@$DIGEST"`))
+	assert.Assert(t, strings.Contains(generatePublishImageScript(cfg), `echo "app_digest=$APP_DIGEST"`))
+}
+
+func TestReleaseWorkflowBuildsGoBinaries(t *testing.T) {
+	root := makeRepo(t, map[string]string{"go.mod": "module example.com/org/tool\n\ngo 1.16\n"})
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Release: config.ReleaseConfig{
+			Enabled:   true,
+			GoTargets: []string{"linux/amd64", "windows/amd64"},
+			GoPackage: ".",
+		},
+	}
+	w, ok := makeReleaseWorkflow(languages.MakeLanguages(), cfg, root)
+	assert.Assert(t, ok)
+	assert.NilError(t, w.Validate())
+	assert.DeepEqual(t, []string(w.Jobs["release"].Needs), []string{"release-go"})
+	build := w.Jobs["release-go"]
+	assert.Equal(t, len(build.Strategy.Matrix.Include), 2)
+	assert.Assert(t, strings.Contains(build.Steps[2].Run, `-o "dist/tool-$GOOS-$GOARCH$ext" .`))
+	// artifacts of every matrix entry are separate since upload-artifact v4
+	upload := build.Steps[len(build.Steps)-1]
+	assert.Assert(t, strings.Contains(upload.With["name"], "${{ matrix.goos }}"))
+	download := w.Jobs["release"].Steps[1]
+	assert.Equal(t, download.With["pattern"], "release-*")
+	assert.Equal(t, download.With["merge-multiple"], "true")
+}
+
+func TestPublishCratesInDependencyOrder(t *testing.T) {
+	files := map[string]string{
+		"Cargo.toml":    "[workspace]\nmembers = [\"a\", \"b\"]\n",
+		"a/Cargo.toml":  "[package]\nname = \"a\"\n\n[dependencies]\nb = { path = \"../b\" }\n",
+		"b/Cargo.toml":  "[package]\nname = \"b\"\n",
+		"b/src/lib.rs":  "",
+		"a/src/main.rs": "",
+	}
+	root := makeRepo(t, files)
+	cfg := config.CiConfig{
+		JobTimeout: 1,
+		Packages:   config.PackagesConfig{Crates: true, CratesTokenSecret: "TOKEN"},
+	}
+	bc := &bors.BorsConfig{}
+	w := makePublishWorkflow(root, cfg, bc)
+	assert.NilError(t, w.Validate())
+	assert.DeepEqual(t, w.On.Push.Tags, []string{"v*"})
+	assert.NilError(t, bc.Resolve([]actions.Workflow{w}))
+	assert.DeepEqual(t, bc.Status, []string{"publish-crates"})
+	steps := w.Jobs["publish-crates"].Steps
+	// a can not be packaged before b is published
+	assert.Equal(t, steps[2].Run, "cargo publish --dry-run --locked -p b")
+	assert.Equal(t, steps[3].Run, "cargo publish --locked -p b\ncargo publish --locked -p a")
+}
+
+func TestMergeQueueGating(t *testing.T) {
+	cfg := config.CiConfig{
+		Codegen:     true,
+		JobTimeout:  1,
+		MergeGating: config.GatingMergeQueue,
+		MergeQueue: config.MergeQueueConfig{
+			Branch:      "master",
+			MergeMethod: "SQUASH",
+			RulesetPath: "ci/ruleset.json",
+		},
+	}
+	gate := makeGate(cfg)
+	meta := makeMetaWorkflow(gate, cfg)
+	assert.Assert(t, meta.On.MergeGroup != nil)
+	assert.DeepEqual(t, meta.On.Push.Branches, []string{"master"})
+	assert.NilError(t, gate.Resolve([]actions.Workflow{meta}))
+
+	files, err := gate.Files()
+	assert.NilError(t, err)
+	ruleset := string(files["ci/ruleset.json"])
+	assert.Assert(t, strings.Contains(ruleset, `"context": "check-ci-config"`), ruleset)
+	assert.Assert(t, strings.Contains(ruleset, `"merge_method": "SQUASH"`), ruleset)
+
+	meta.On.MergeGroup = nil
+	assert.ErrorContains(t, gate.Resolve([]actions.Workflow{meta}), "does not run on merge_group")
+}
+
+func TestAggregatedCiSuccess(t *testing.T) {
+	cfg := config.CiConfig{
+		NoE2e:           true,
+		JobTimeout:      1,
+		AggregateChecks: true,
+	}
+	bc := &bors.BorsConfig{}
+	w := makeCiWorkflow(nil, cfg, t.TempDir(), bc)
+	assert.NilError(t, w.Validate())
+	assert.DeepEqual(t, w.Jobs["ci-success"].Needs, actions.StringList{"misspell"})
+	assert.NilError(t, bc.Resolve([]actions.Workflow{w}))
+	assert.DeepEqual(t, bc.Status, []string{"ci-success"})
+}
+
+func TestGeneratedFilesManifest(t *testing.T) {
+	out := t.TempDir()
+
+	files := newGeneratedFiles()
+	files.add("a.txt", []byte("a"))
+	files.add("b.txt", []byte("b"))
+	assert.NilError(t, files.commit(out, false))
+
+	// b.txt is not produced anymore, c.txt was written by hand
+	assert.NilError(t, os.WriteFile(path.Join(out, "c.txt"), []byte("manual"), 0o644))
+	files = newGeneratedFiles()
+	files.add("a.txt", []byte("a2"))
+	files.add("c.txt", []byte("c"))
+	assert.ErrorContains(t, files.commit(out, false), "c.txt")
+
+	assert.NilError(t, files.commit(out, true))
+	_, err := os.Stat(path.Join(out, "b.txt"))
+	assert.Assert(t, os.IsNotExist(err))
+	data, err := os.ReadFile(path.Join(out, "c.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "c\n")
+}
+
+func TestStaleFilesEditedByHandAreKept(t *testing.T) {
+	out := t.TempDir()
+	files := newGeneratedFiles()
+	files.add(".github/workflows/old.yaml", []byte("name: old\n"))
+	files.add("ci/old.sh", []byte("echo old"))
+	assert.NilError(t, files.commit(out, false))
+	for _, name := range []string{".github/workflows/old.yaml", "ci/old.sh"} {
+		data, err := os.ReadFile(path.Join(out, name))
+		assert.NilError(t, err)
+		assert.NilError(t, os.WriteFile(path.Join(out, name), append(data, "# edited\n"...), 0o644))
+	}
+
+	files = newGeneratedFiles()
+	files.add("a.txt", []byte("a"))
+	assert.ErrorContains(t, files.commit(out, false), "edited by hand: [.github/workflows/old.yaml ci/old.sh]")
+	_, err := os.Stat(path.Join(out, "a.txt"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.NilError(t, files.commit(out, true))
+	_, err = os.Stat(path.Join(out, "ci/old.sh"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestDetectAndAdoptHandEdits(t *testing.T) {
+	out := t.TempDir()
+	generated := actions.Workflow{
+		Name: "ci",
+		Jobs: map[string]actions.Job{
+			"test": {Timeout: 1, Steps: []actions.Step{{Name: "a"}, {Name: "b"}}},
+			"lint": {Timeout: 1},
+		},
+	}
+	data, err := yaml.Marshal(generated)
+	assert.NilError(t, err)
+	files := newGeneratedFiles()
+	files.add(".github/workflows/ci.yaml", data)
+	assert.NilError(t, files.commit(out, false))
+
+	edited := actions.Workflow{
+		Name: "ci",
+		Jobs: map[string]actions.Job{
+			"test": {Timeout: 5, Steps: []actions.Step{{Name: "a"}, {Name: "x"}, {Name: "b"}}},
+		},
+	}
+	editedData, err := yaml.Marshal(edited)
+	assert.NilError(t, err)
+	_, body, _ := unstamp(files.data(".github/workflows/ci.yaml"))
+	hash := hashContent(body)
+	assert.NilError(t, os.WriteFile(path.Join(out, ".github/workflows/ci.yaml"),
+		append([]byte(generatedHeader+" sha256:"+hash+"\n"), editedData...), 0o644))
+
+	edits, err := findHandEdits(out, files)
+	assert.NilError(t, err)
+	assert.Equal(t, len(edits), 1)
+	diff := lineDiff(edits[0].generated, edits[0].current)
+	assert.Assert(t, strings.Contains(diff, "\n+    - name: x\n"), diff)
+
+	overrides, err := workflowEditOverrides(generated, edited)
+	assert.NilError(t, err)
+	assert.Assert(t, overrides["lint"].Disabled)
+	assert.Equal(t, overrides["test"].Timeout, 5)
+	assert.DeepEqual(t, overrides["test"].InsertSteps, []config.StepInsertion{
+		{Before: "b", Steps: []actions.Step{{Name: "x"}}},
+	})
+
+	// config is written in the same unit, but is not owned by the generator
+	adopted, cfgData, err := adoptEdits(out, edits)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, adopted, []string{".github/workflows/ci.yaml"})
+	files = newGeneratedFiles()
+	files.add(".github/workflows/ci.yaml", data)
+	files.addUserFile(config.ConfigPath, cfgData)
+	assert.NilError(t, files.commit(out, true))
+	written, err := os.ReadFile(path.Join(out, config.ConfigPath))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(written), "timeoutMinutes: 5"), string(written))
+	m, _, err := loadManifest(out)
+	assert.NilError(t, err)
+	_, owned := m.Files[config.ConfigPath]
+	assert.Assert(t, !owned)
+}
+
+func TestExplainShowsProvenance(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/tool\n",
+		"ci/config.yaml": `noPublish: true
+noE2e: true
+buildTimeoutMinutes: 5
+overrides:
+  go-lint:
+    timeoutMinutes: 10
+  misspell:
+    disabled: true
+`,
+	}
+	root := makeRepo(t, files)
+	var out strings.Builder
+	assert.NilError(t, explain(&out, generate(root)))
+	s := out.String()
+	assert.Assert(t, strings.Contains(s, "  job go-lint\n    from: language golang detected by go.mod\n    from: overrides.go-lint in ci/config.yaml\n    required check: go-lint\n"), s)
+	assert.Assert(t, strings.Contains(s, "job misspell disabled by overrides.misspell"), s)
+}
+
+func TestInitGuessesConfig(t *testing.T) {
+	root := makeRepo(t, map[string]string{
+		"Cargo.toml":                 "",
+		"ci/e2e-build.sh":            "",
+		"server/Dockerfile":          "",
+		".github/workflows/docs.yml": "",
+	})
+	inspection, err := inspectRepo(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, inspection.dockerfiles, []string{"server/Dockerfile"})
+	assert.DeepEqual(t, inspection.workflows, []string{".github/workflows/docs.yml"})
+
+	assert.NilError(t, os.WriteFile(path.Join(root, config.ConfigPath), []byte(renderStarterConfig(root, inspection)), 0o644))
+	cfg, err := config.Load(root)
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.NoE2e, false)
+	assert.Equal(t, cfg.BuildTimeout, 45)
+	assert.Equal(t, cfg.DockerImages[0].Name, "server")
+	assert.Equal(t, cfg.DockerImages[0].Context, "server")
+}
+
+func TestImageNamesAreUnique(t *testing.T) {
+	dockerfiles := []string{"a/api/Dockerfile", "b/api/Dockerfile", "x/a/api/Dockerfile", "web/Dockerfile", "api/Dockerfile"}
+	assert.DeepEqual(t, imageNames(t.TempDir(), dockerfiles), []string{"a-api", "b-api", "x-a-api", "web", "api"})
+	// root Dockerfile is named after the repository directory
+	repo := path.Join(t.TempDir(), "api")
+	assert.DeepEqual(t, imageNames(repo, []string{"Dockerfile", "api/Dockerfile"}), []string{"api", "api-2"})
+}
+
+func TestImportHandWrittenWorkflows(t *testing.T) {
+	workflow := `name: legacy
+on: [push, pull_request]
+env:
+  GOFLAGS: -mod=mod
+jobs:
+  test:
+    runs-on: ubuntu-22.04
+    steps:
+      - uses: actions/checkout@v3
+      - name: Install golang
+        uses: actions/setup-go@v4
+        with:
+          go-version: 1.16.4
+      - run: go generate ./...
+      - run: go test .
+  docs:
+    name: Build docs
+    runs-on: ubuntu-22.04
+    needs: test
+    steps:
+      - run: make docs
+`
+	root := makeRepo(t, map[string]string{
+		"go.mod":                       "module example.com/x\n",
+		".github/workflows/legacy.yml": workflow,
+		".github/workflows/broken.yml": "on: push\njobs: [\n",
+	})
+	cfg, err := config.Parse([]byte("buildTimeoutMinutes: 5\nnoPublish: true\n"))
+	assert.NilError(t, err)
+
+	im := newImporter(root, cfg)
+	assert.NilError(t, im.importWorkflows(root, []string{".github/workflows/broken.yml", ".github/workflows/legacy.yml"}))
+	assert.Assert(t, strings.HasPrefix(im.problems[0], ".github/workflows/broken.yml: skipped, failed to parse"), im.problems)
+	assert.DeepEqual(t, im.overrides["go-lint"], config.JobOverride{Disabled: true})
+	testOverride := im.overrides["go-test"]
+	assert.DeepEqual(t, testOverride.Env, map[string]string{"GOFLAGS": "-mod=mod"})
+	assert.Equal(t, testOverride.InsertSteps[0].Before, "Run tests")
+	assert.Equal(t, testOverride.InsertSteps[0].Steps[0].Run, "go generate ./...")
+	assert.DeepEqual(t, im.customJobs["docs"].Needs, actions.StringList{"go-test"})
+
+	proposal, err := mergeIntoConfig([]byte("buildTimeoutMinutes: 5\nnoPublish: true\n"), im.overrides, im.customJobs)
+	assert.NilError(t, err)
+	imported, err := config.Parse(proposal)
+	assert.NilError(t, err)
+	assert.Equal(t, imported.CustomJobs["docs"].Steps[0].Run, "make docs")
+	assert.Equal(t, imported.Overrides["go-lint"].Disabled, true)
+	// display name would not match the job key
+	assert.Equal(t, imported.CustomJobs["docs"].Name, "")
+	assert.Assert(t, strings.Contains(strings.Join(im.problems, "\n"), `job docs: display name "Build docs" is dropped`), im.problems)
+	assert.NilError(t, validateProposal(root, imported))
+}
+
+func TestPreflightReportsBrokenReferences(t *testing.T) {
+	root := makeRepo(t, map[string]string{
+		"ci/build.sh":  "mkdir -p out\n",
+		"ci/broken.sh": "if then\n",
+		"ci/plain.sh":  "true\n",
+	})
+	upload := func(p string) actions.Step {
+		return actions.Step{Name: "upload " + p, Uses: actions.UploadArtifact.Ref(), With: map[string]string{"path": p}}
+	}
+	w := actions.Workflow{
+		Name: "ci",
+		Jobs: map[string]actions.Job{
+			"build": {Steps: []actions.Step{
+				{Name: "build", Run: "bash ci/build.sh"},
+				upload("out/*.tar"),
+				upload("logs"),
+				{Name: "missing", Run: "ci/missing.sh"},
+				{Name: "plain", Run: "./ci/plain.sh --flag"},
+				{Name: "broken", Run: "bash ci/broken.sh"},
+				{Name: "generated", Run: "bash ci/publish-images.sh"},
+			}},
+		},
+	}
+	files := newGeneratedFiles()
+	files.add("ci/publish-images.sh", []byte("echo ok"))
+	p := newPreflight(root, files)
+	assert.ErrorContains(t, p.check([]actions.Workflow{w}), "pre-flight checks failed")
+	// produced paths are guessed, so only missing scripts are errors
+	assert.DeepEqual(t, p.warnings, []string{
+		`workflow ci, job build, step "upload logs": uploaded path logs is not produced by earlier steps`,
+	})
+	expected := []string{
+		`workflow ci, job build, step "missing": ci/missing.sh does not exist`,
+		`workflow ci, job build, step "plain": ci/plain.sh is not executable`,
+	}
+	if p.checkSyntax {
+		expected = append(expected, `workflow ci, job build, step "broken": ci/broken.sh has syntax errors`)
+	}
+	assert.Equal(t, len(p.problems), len(expected))
+	for i, problem := range expected {
+		assert.Assert(t, strings.HasPrefix(p.problems[i], problem), p.problems[i])
+	}
+}
+
+func TestE2eShardsAndSuites(t *testing.T) {
+	cfg, err := config.Parse([]byte(`buildTimeoutMinutes: 5
+noPublish: true
+e2e:
+  shards: 2
+  retentionDays: 5
+  services:
+    postgres:
+      image: postgres:16
+      ports: ["5432:5432"]
+  suites:
+    - name: api
+    - name: ui
+      runScript: ci/e2e-ui.sh
+      shards: 1
+`))
+	assert.NilError(t, err)
+	jobs := makeCiE2eJobs(t.TempDir(), cfg, nil)
+	assert.DeepEqual(t, actions.OrderJobs(jobs), []string{"e2e-build", "e2e-run-api", "e2e-run-ui"})
+
+	api := jobs["e2e-run-api"]
+	assert.Equal(t, api.Env["E2E_TOTAL"], "2")
+	assert.Equal(t, api.Services["postgres"].Image, "postgres:16")
+	names, err := api.CheckNames("e2e-run-api")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"e2e-run-api (1)", "e2e-run-api (2)"})
+	assert.Equal(t, api.Steps[3].With["name"], "e2e-logs-api-${{ matrix.shard }}")
+	assert.Equal(t, api.Steps[3].With["retention-days"], "5")
+
+	ui := jobs["e2e-run-ui"]
+	assert.Assert(t, ui.Strategy == nil)
+	assert.Equal(t, ui.Steps[2].Run, "bash ci/e2e-ui.sh")
+}
+
+func TestE2eKindCluster(t *testing.T) {
+	cfg, err := config.Parse([]byte(`buildTimeoutMinutes: 5
+noPublish: true
+dockerImages:
+  - name: apiserver
+    context: src/apiserver
+  - name: invoker
+e2e:
+  kind:
+    chart: k8s/jjs
+`))
+	assert.NilError(t, err)
+	jobs := makeCiE2eJobs(t.TempDir(), cfg, nil)
+
+	build := jobs["e2e-build"]
+	stepNames := make([]string, 0)
+	for _, s := range build.Steps {
+		stepNames = append(stepNames, s.Name)
+	}
+	assert.DeepEqual(t, stepNames, []string{"Fetch sources", "Build e2e artifacts", "Build image apiserver", "Save images", "Upload e2e artifacts"})
+	assert.Equal(t, build.Steps[3].Run, "mkdir -p e2e-artifacts\ndocker save --output e2e-artifacts/e2e-images.tar apiserver:e2e invoker:e2e")
+
+	run := jobs["e2e-run"]
+	stepNames = stepNames[:0]
+	for _, s := range run.Steps {
+		stepNames = append(stepNames, s.Name)
+	}
+	assert.DeepEqual(t, stepNames, []string{
+		"Fetch sources", "Download e2e artifacts", "Create kind cluster", "Load images into cluster",
+		"Install Helm", "Install Helm chart", "Execute tests", "Collect cluster logs", "Upload logs",
+	})
+	assert.Equal(t, run.Steps[5].Run, "helm install e2e k8s/jjs --wait --timeout 5m")
+	assert.Equal(t, run.Steps[7].If, "failure()")
+
+	_, err = config.Parse([]byte("buildTimeoutMinutes: 5\nnoPublish: true\ne2e:\n  kind: {}\n"))
+	assert.ErrorContains(t, err, "requires dockerImages")
+}
+
+func TestPathFiltersSkipUnaffectedJobs(t *testing.T) {
+	root := makeRepo(t, map[string]string{"go.mod": "module example.com/x\n"})
+	cfg, err := config.Parse([]byte("buildTimeoutMinutes: 5\nnoPublish: true\nnoE2e: true\npathFilters: true\n"))
+	assert.NilError(t, err)
+	bc := &bors.BorsConfig{}
+	w := makeCiWorkflow(languages.MakeLanguages(), cfg, root, bc)
+	assert.NilError(t, w.Validate())
+
+	changes := w.Jobs["changes"]
+	assert.Equal(t, changes.Outputs["golang"], "${{ steps.filter.outputs.golang || 'true' }}")
+	assert.Equal(t, changes.Steps[1].If, "github.ref != 'refs/heads/staging' && github.ref != 'refs/heads/trying'")
+	assert.Assert(t, strings.Contains(changes.Steps[1].With["filters"], "golang:\n  - '**/*.go'\n"))
+
+	goTest := w.Jobs["go-test"]
+	assert.DeepEqual(t, goTest.Needs, actions.StringList{"changes"})
+	assert.Equal(t, goTest.If, "needs.changes.outputs.golang == 'true'")
+	assert.Equal(t, w.Jobs["misspell"].If, "")
+
+	assert.NilError(t, bc.Resolve([]actions.Workflow{w}))
+	assert.DeepEqual(t, bc.Status, []string{"misspell", "go-lint", "go-test", "changes"})
+}
+
+func TestPathFiltersApplyToE2eAndOverrides(t *testing.T) {
+	root := makeRepo(t, map[string]string{
+		"go.mod":          "module example.com/x\n",
+		"ci/e2e-build.sh": "mkdir -p e2e-artifacts\n",
+		"ci/e2e-run.sh":   "mkdir -p e2e-logs\n",
+		"ci/config.yaml": `noPublish: true
+buildTimeoutMinutes: 5
+pathFilters: true
+dockerImages:
+  - name: api
+    context: api
+overrides:
+  go-test:
+    if: github.actor != 'bot'
+`,
+	})
+	g := generate(root)
+	var ci actions.Workflow
+	for _, w := range g.workflows {
+		if w.Name == "ci" {
+			ci = w
+		}
+	}
+	assert.NilError(t, ci.Validate())
+	filters := ci.Jobs["changes"].Steps[1].With["filters"]
+	assert.Assert(t, strings.Contains(filters, "e2e:\n  - '**/*.go'\n  - 'go.mod'\n  - 'go.sum'\n  - 'ci/e2e-build.sh'\n  - 'ci/e2e-run.sh'\n  - 'api/**'\n"), filters)
+	for _, jobName := range []string{"e2e-build", "e2e-run"} {
+		assert.Equal(t, ci.Jobs[jobName].If, "needs.changes.outputs.e2e == 'true'")
+	}
+	// override must not drop the path filter
+	assert.Equal(t, ci.Jobs["go-test"].If, "(needs.changes.outputs.golang == 'true') && (github.actor != 'bot')")
+}
+
+func TestCustomJobCallingReusableWorkflow(t *testing.T) {
+	root := makeRepo(t, map[string]string{
+		"go.mod": "module example.com/tool\n",
+		"ci/config.yaml": `noPublish: true
+noE2e: true
+buildTimeoutMinutes: 5
+customJobs:
+  reuse:
+    uses: ./.github/workflows/reusable.yaml
+    secrets: inherit
+`,
+	})
+	g := generate(root)
+	var ci actions.Workflow
+	for _, w := range g.workflows {
+		if w.Name == "ci" {
+			ci = w
+		}
+	}
+	reuse := ci.Jobs["reuse"]
+	assert.Assert(t, reuse.RunsOn.IsZero())
+	assert.Equal(t, reuse.Timeout, 0)
+	assert.NilError(t, ci.Validate())
+	assert.Assert(t, !strings.Contains(string(g.files.data("bors.toml")), "reuse"))
+}
+
+func TestLockOnlyUsedActions(t *testing.T) {
+	w := actions.Workflow{
+		Name: "ci",
+		Jobs: map[string]actions.Job{
+			"a": {Steps: []actions.Step{{Uses: actions.Checkout.Ref()}, {Uses: "./local"}, {Run: "true"}}},
+			"b": {Steps: []actions.Step{{Uses: actions.SetupGo.Ref()}, {Uses: actions.Checkout.Ref()}}},
+			"c": {Steps: []actions.Step{{Uses: "org/pinned@0123456789abcdef0123456789abcdef01234567"}}},
+		},
+	}
+	assert.DeepEqual(t, usedActions([]actions.Workflow{w}), []actions.Action{actions.Checkout, actions.SetupGo})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/jjs-dev/ci-config-gen/fileset"
+)
+
+// manifestPath lists files owned by the generator, relative to the output
+// directory.
+const manifestPath = "ci/.generated.json"
+
+type manifest struct {
+	// Files maps file path to sha256 of its generated content
+	Files map[string]string `json:"files"`
+}
+
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadManifest reads manifest from the output directory. ok is false if
+// there is no manifest, e.g. files were produced by an older generator.
+func loadManifest(out string) (m manifest, ok bool, err error) {
+	data, err := os.ReadFile(path.Join(out, manifestPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{Files: map[string]string{}}, false, nil
+	}
+	if err != nil {
+		return manifest{}, false, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, false, fmt.Errorf("failed to parse %s: %w", manifestPath, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]string{}
+	}
+	return m, true, nil
+}
+
+// generatedFiles collects generator outputs, so that nothing is written until
+// generation succeeded.
+type generatedFiles struct {
+	set *fileset.FileSet
+	// userFiles are written along with generated files, but are not owned
+	// by the generator
+	userFiles map[string]bool
+}
+
+func newGeneratedFiles() *generatedFiles {
+	return &generatedFiles{set: fileset.New(), userFiles: make(map[string]bool)}
+}
+
+func (g *generatedFiles) add(relName string, data []byte) {
+	data = fileset.Normalize(data)
+	if stampable(relName) {
+		data = stamp(data)
+	}
+	if err := g.set.Add(relName, data); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// addUserFile adds file which is not generated, e.g. config with adopted
+// edits, so that it is written in the same unit with generated files.
+func (g *generatedFiles) addUserFile(relName string, data []byte) {
+	if err := g.set.Add(relName, data); err != nil {
+		log.Fatal(err)
+	}
+	g.userFiles[relName] = true
+}
+
+func (g *generatedFiles) names() []string {
+	return g.set.Names()
+}
+
+func (g *generatedFiles) data(relName string) []byte {
+	return g.set.Data(relName)
+}
+
+// unownedConflicts returns files which exist, are not listed in the manifest
+// and would be changed.
+func (g *generatedFiles) unownedConflicts(out string, m manifest) ([]string, error) {
+	conflicts := make([]string, 0)
+	for _, name := range g.names() {
+		if _, owned := m.Files[name]; owned || g.userFiles[name] {
+			continue
+		}
+		existing, err := os.ReadFile(path.Join(out, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hashContent(existing) != hashContent(g.data(name)) {
+			conflicts = append(conflicts, name)
+		}
+	}
+	return conflicts, nil
+}
+
+// editedStaleFiles returns stale files whose content differs from the one
+// generated. Embedded hash is checked for stamped files, and hash from the
+// manifest for the rest.
+func editedStaleFiles(out string, m manifest, stale []string) ([]string, error) {
+	edited := make([]string, 0)
+	for _, name := range stale {
+		data, err := os.ReadFile(path.Join(out, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stampable(name) {
+			if hash, current, ok := unstamp(data); ok {
+				if hash != hashContent(current) {
+					edited = append(edited, name)
+				}
+				continue
+			}
+		}
+		if hashContent(data) != m.Files[name] {
+			edited = append(edited, name)
+		}
+	}
+	return edited, nil
+}
+
+// commit writes all files and the manifest, and removes files which were
+// generated previously but are not produced anymore. Files not owned by the
+// generator are only overwritten, and stale files edited by hand are only
+// removed, if force is set.
+func (g *generatedFiles) commit(out string, force bool) error {
+	old, hasManifest, err := loadManifest(out)
+	if err != nil {
+		return err
+	}
+	if hasManifest && !force {
+		conflicts, err := g.unownedConflicts(out, old)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) != 0 {
+			return fmt.Errorf("refusing to overwrite files not owned by generator: %v (use --force to overwrite)", conflicts)
+		}
+	}
+	if !hasManifest {
+		log.Printf("%s not found, taking ownership of existing files", manifestPath)
+	}
+	stale := make([]string, 0)
+	for name := range old.Files {
+		if !g.set.Has(name) {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	if !force {
+		edited, err := editedStaleFiles(out, old, stale)
+		if err != nil {
+			return err
+		}
+		if len(edited) != 0 {
+			return fmt.Errorf("refusing to remove stale generated files edited by hand: %v (use --force to remove)", edited)
+		}
+	}
+
+	m := manifest{Files: make(map[string]string)}
+	for _, name := range g.names() {
+		if g.userFiles[name] {
+			continue
+		}
+		m.Files[name] = hashContent(g.data(name))
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := g.set.Add(manifestPath, data); err != nil {
+		return err
+	}
+	if err := g.set.Write(out); err != nil {
+		return err
+	}
+
+	for _, name := range stale {
+		log.Printf("Removing stale generated file %s", name)
+		err := os.Remove(path.Join(out, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/gating"
+)
+
+// overrideSet applies user-provided job overrides and remembers which of them
+// were used, so that overrides for non-existent jobs can be reported.
+type overrideSet struct {
+	overrides map[string]config.JobOverride
+	used      map[string]bool
+}
+
+func newOverrideSet(overrides map[string]config.JobOverride) *overrideSet {
+	return &overrideSet{
+		overrides: overrides,
+		used:      make(map[string]bool),
+	}
+}
+
+func findStep(steps []actions.Step, name string) int {
+	for i, step := range steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertSteps(steps []actions.Step, pos int, extra []actions.Step) []actions.Step {
+	res := make([]actions.Step, 0, len(steps)+len(extra))
+	res = append(res, steps[:pos]...)
+	res = append(res, extra...)
+	res = append(res, steps[pos:]...)
+	return res
+}
+
+func applyOverride(job actions.Job, o config.JobOverride) (actions.Job, error) {
+	if o.Timeout != 0 {
+		job.Timeout = o.Timeout
+	}
+	if !o.RunsOn.IsZero() {
+		job.RunsOn = o.RunsOn
+	}
+	// condition of the generated job, e.g. path filter, must still hold
+	if o.If != "" && job.If != "" {
+		job.If = fmt.Sprintf("(%s) && (%s)", job.If, o.If)
+	} else if o.If != "" {
+		job.If = o.If
+	}
+	if len(o.Env) != 0 {
+		env := make(map[string]string)
+		for k, v := range job.Env {
+			env[k] = v
+		}
+		for k, v := range o.Env {
+			env[k] = v
+		}
+		job.Env = env
+	}
+	for _, ins := range o.InsertSteps {
+		anchor := ins.Before
+		if anchor == "" {
+			anchor = ins.After
+		}
+		pos := findStep(job.Steps, anchor)
+		if pos == -1 {
+			return actions.Job{}, fmt.Errorf("step %q not found", anchor)
+		}
+		if ins.After != "" {
+			pos++
+		}
+		job.Steps = insertSteps(job.Steps, pos, ins.Steps)
+	}
+	return job, nil
+}
+
+// collectorJobs only gather results or outputs of jobs they need, so they
+// still work when some of them are disabled.
+var collectorJobs = map[string]bool{
+	"ci-success": true,
+	"release":    true,
+}
+
+// apply patches all jobs of the workflow. Disabled jobs are removed both from
+// the workflow and from the required checks.
+func (s *overrideSet) apply(w actions.Workflow, gate gating.Gate) (actions.Workflow, error) {
+	jobs := make(map[string]actions.Job)
+	for jobName, job := range w.Jobs {
+		o, ok := s.overrides[jobName]
+		if !ok {
+			jobs[jobName] = job
+			continue
+		}
+		s.used[jobName] = true
+		if o.Disabled {
+			gate.RemoveJob(jobName)
+			continue
+		}
+		patched, err := applyOverride(job, o)
+		if err != nil {
+			return actions.Workflow{}, fmt.Errorf("failed to apply override for job %s: %w", jobName, err)
+		}
+		patched.Provenance = append(patched.Provenance, fmt.Sprintf("overrides.%s in %s", jobName, config.ConfigPath))
+		jobs[jobName] = patched
+	}
+	// collectors no longer wait for disabled jobs, other jobs can not run
+	// without them
+	broken := make([]string, 0)
+	for jobName, job := range jobs {
+		if len(job.Needs) == 0 {
+			continue
+		}
+		needs := make(actions.StringList, 0, len(job.Needs))
+		for _, need := range job.Needs {
+			if _, ok := jobs[need]; ok {
+				needs = append(needs, need)
+			} else if !collectorJobs[jobName] {
+				broken = append(broken, fmt.Sprintf("%s needs disabled job %s", jobName, need))
+			}
+		}
+		job.Needs = needs
+		jobs[jobName] = job
+	}
+	if len(broken) != 0 {
+		sort.Strings(broken)
+		return actions.Workflow{}, fmt.Errorf("jobs depend on disabled jobs, disable them too: %s", strings.Join(broken, ", "))
+	}
+	w.Jobs = jobs
+	return w, nil
+}
+
+// checkAllUsed returns error if some overrides did not match any job.
+func (s *overrideSet) checkAllUsed() error {
+	unused := make([]string, 0)
+	for jobName := range s.overrides {
+		if !s.used[jobName] {
+			unused = append(unused, jobName)
+		}
+	}
+	if len(unused) != 0 {
+		sort.Strings(unused)
+		return fmt.Errorf("overrides target unknown jobs: %v", unused)
+	}
+	return nil
+}
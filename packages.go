@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/languages"
+)
+
+const onReleaseTag = "startsWith(github.ref, 'refs/tags/v')"
+
+// makeCratesJob publishes workspace crates in dependency order on `v*` tags,
+// and checks that they can be packaged otherwise.
+func makeCratesJob(root string, cfg config.CiConfig) (actions.Job, error) {
+	crates, err := languages.CratePublishOrder(root)
+	if err != nil {
+		return actions.Job{}, err
+	}
+	if len(crates) == 0 {
+		return actions.Job{}, fmt.Errorf("workspace has no publishable crates")
+	}
+	// crates depending on other workspace crates can not be packaged until
+	// new versions of dependencies are on crates.io, so they are only
+	// verified by publish itself
+	dryRun := make([]string, 0)
+	publish := make([]string, 0)
+	for _, crate := range crates {
+		if !crate.HasWorkspaceDeps {
+			dryRun = append(dryRun, fmt.Sprintf("cargo publish --dry-run --locked -p %s", crate.Name))
+		}
+		publish = append(publish, fmt.Sprintf("cargo publish --locked -p %s", crate.Name))
+	}
+	return actions.Job{
+		RunsOn:  cfg.Runners.Resolve("rust", "publish-crates"),
+		Timeout: cfg.JobTimeout,
+		Steps: []actions.Step{
+			actions.MakeCheckoutStep(),
+			{
+				Name: "Install stable toolchain",
+				Uses: actions.RustToolchain.Ref(),
+				With: map[string]string{
+					"toolchain": "stable",
+					"override":  "true",
+				},
+			},
+			{
+				Name: "Check crates can be published",
+				If:   "!" + onReleaseTag,
+				Run:  strings.Join(dryRun, "\n"),
+			},
+			{
+				Name: "Publish crates",
+				If:   onReleaseTag,
+				Run:  strings.Join(publish, "\n"),
+				Env: map[string]string{
+					"CARGO_REGISTRY_TOKEN": fmt.Sprintf("${{ secrets.%s }}", cfg.Packages.CratesTokenSecret),
+				},
+			},
+		},
+	}, nil
+}
+
+// verifyGoTagScript checks that the tag is a semantic version compatible with
+// the module path and that the module proxy can resolve it.
+const verifyGoTagScript = `TAG="${GITHUB_REF#refs/tags/}"
+if ! [[ "$TAG" =~ ^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$ ]]
+then
+  echo "tag $TAG is not a valid Go module version vX.Y.Z"
+  exit 1
+fi
+MAJOR="${BASH_REMATCH[1]}"
+if [ "$MAJOR" -ge 2 ] && [[ "$MODULE" != */v$MAJOR ]]
+then
+  echo "module path $MODULE must end with /v$MAJOR for tag $TAG"
+  exit 1
+fi
+if [ "$MAJOR" -lt 2 ] && [[ "$MODULE" =~ /v[0-9]+$ ]]
+then
+  echo "module path $MODULE has major version suffix, but tag $TAG is v0/v1"
+  exit 1
+fi
+# resolve outside of the module, so that the main module does not shadow it
+cd "$(mktemp -d)"
+GO111MODULE=on GOPROXY=https://proxy.golang.org GOFLAGS=-mod=mod go list -m "$MODULE@$TAG"`
+
+func makeGoModuleTagJob(root string, cfg config.CiConfig) (actions.Job, error) {
+	module, err := languages.GoModulePath(root)
+	if err != nil {
+		return actions.Job{}, err
+	}
+	return actions.Job{
+		RunsOn:  cfg.Runners.Resolve("golang", "verify-go-module-tag"),
+		If:      onReleaseTag,
+		Timeout: cfg.JobTimeout,
+		Env: map[string]string{
+			"MODULE": module,
+		},
+		Steps: []actions.Step{
+			languages.MakeSetupGoStep(),
+			{
+				Name: "Verify module version",
+				Run:  verifyGoTagScript,
+			},
+		},
+	}, nil
+}
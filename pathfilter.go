@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/bors"
+	"github.com/jjs-dev/ci-config-gen/config"
+)
+
+// changesJobName is the job detecting which parts of the repository changed.
+const changesJobName = "changes"
+
+// e2eFilterName is the filter of e2e jobs.
+const e2eFilterName = "e2e"
+
+// alwaysRelevantPaths change CI itself, so they make all jobs run.
+var alwaysRelevantPaths = []string{config.ConfigPath, ".github/workflows/**"}
+
+// e2ePaths returns paths affecting e2e tests: sources of all languages,
+// e2e scripts, image contexts and the Helm chart.
+func e2ePaths(cfg config.CiConfig, filters map[string][]string) []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			res = append(res, p)
+		}
+	}
+	for _, name := range names {
+		for _, p := range filters[name] {
+			add(p)
+		}
+	}
+	add(cfg.E2e.BuildScript)
+	add(cfg.E2e.RunScript)
+	for _, suite := range cfg.E2e.Suites {
+		add(suite.RunScript)
+	}
+	for _, image := range cfg.DockerImages {
+		if image.BuiltByGenerator() {
+			add(path.Join(image.Context, "**"))
+			if image.Dockerfile != "" {
+				add(image.Dockerfile)
+			}
+		}
+	}
+	if cfg.E2e.Kind != nil {
+		if cfg.E2e.Kind.Chart != "" {
+			add(path.Join(cfg.E2e.Kind.Chart, "**"))
+		}
+		add(cfg.E2e.Kind.ChartValues)
+	}
+	return res
+}
+
+// notMergeCandidateCondition is false for runs testing merge candidates.
+// They run all jobs, so that required checks are never skipped.
+func notMergeCandidateCondition(cfg config.CiConfig) string {
+	if cfg.MergeGating == config.GatingMergeQueue {
+		return "github.event_name != 'merge_group'"
+	}
+	conds := make([]string, 0)
+	for _, branch := range (&bors.BorsConfig{}).Branches() {
+		conds = append(conds, fmt.Sprintf("github.ref != 'refs/heads/%s'", branch))
+	}
+	return strings.Join(conds, " && ")
+}
+
+// makeChangesJob creates job with an output per filter, which is 'true' if
+// files matching the filter changed. For merge candidates all outputs are
+// 'true'.
+func makeChangesJob(cfg config.CiConfig, filters map[string][]string) actions.Job {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var spec strings.Builder
+	outputs := make(map[string]string)
+	for _, name := range names {
+		fmt.Fprintf(&spec, "%s:\n", name)
+		for _, p := range append(filters[name], alwaysRelevantPaths...) {
+			fmt.Fprintf(&spec, "  - '%s'\n", p)
+		}
+		// skipped filter step produces no outputs
+		outputs[name] = fmt.Sprintf("${{ steps.filter.outputs.%s || 'true' }}", name)
+	}
+	return actions.Job{
+		Comment:    "Detects changed files, so that unaffected jobs are skipped",
+		Provenance: []string{"pathFilters: true"},
+		RunsOn:     cfg.Runners.Resolve("", changesJobName),
+		Timeout:    2,
+		// pull request files are listed using API
+		Permissions: actions.Permissions{
+			"contents":      actions.PermissionRead,
+			"pull-requests": actions.PermissionRead,
+		},
+		Outputs: outputs,
+		Steps: []actions.Step{
+			actions.MakeCheckoutStep(),
+			{
+				Id:   "filter",
+				Name: "Detect changes",
+				If:   notMergeCandidateCondition(cfg),
+				Uses: actions.PathsFilter.Ref(),
+				With: map[string]string{
+					"filters": spec.String(),
+				},
+			},
+		},
+	}
+}
+
+// applyPathFilters makes jobs wait for the changes job and skip if their
+// filter did not match. jobFilters maps job names to filter names.
+func applyPathFilters(jobs map[string]actions.Job, jobFilters map[string]string) {
+	for jobName, filter := range jobFilters {
+		job := jobs[jobName]
+		cond := fmt.Sprintf("needs.%s.outputs.%s == 'true'", changesJobName, filter)
+		if job.If != "" {
+			cond = fmt.Sprintf("(%s) && (%s)", cond, job.If)
+		}
+		job.If = cond
+		job.Needs = append(append(actions.StringList{}, job.Needs...), changesJobName)
+		jobs[jobName] = job
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+)
+
+// scriptRefRegexp matches repository scripts invoked from `run` steps.
+// Scripts not prefixed with an interpreter are executed directly.
+var scriptRefRegexp = regexp.MustCompile(`(?m)(?:^|[\s;&|(])((?:bash|sh)\s+)?(?:\./)?(ci/[A-Za-z0-9_./-]+\.sh)\b`)
+
+// preflight checks files referenced by workflows, so that misconfiguration is
+// found before CI runs. Scripts may be either generated or present in the
+// repository. Uploaded paths are found heuristically, so unproduced ones are
+// only warned about.
+type preflight struct {
+	repoRoot string
+	files    *generatedFiles
+	// checkSyntax is false when bash is not available
+	checkSyntax bool
+	problems    []string
+	warnings    []string
+}
+
+func newPreflight(repoRoot string, files *generatedFiles) *preflight {
+	_, err := exec.LookPath("bash")
+	return &preflight{repoRoot: repoRoot, files: files, checkSyntax: err == nil}
+}
+
+func (p *preflight) reportf(format string, args ...interface{}) {
+	p.problems = append(p.problems, fmt.Sprintf(format, args...))
+}
+
+func (p *preflight) warnf(format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+// readScript returns content of the script and whether it is executable.
+func (p *preflight) readScript(name string) ([]byte, bool, error) {
+	if data := p.files.data(name); data != nil {
+		return data, true, nil
+	}
+	full := path.Join(p.repoRoot, name)
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(full)
+	return data, info.Mode()&0o111 != 0, err
+}
+
+func bashSyntaxError(script []byte) error {
+	cmd := exec.Command("bash", "-n")
+	cmd.Stdin = bytes.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// checkScripts verifies scripts referenced by the step and returns their
+// contents.
+func (p *preflight) checkScripts(where string, step actions.Step) [][]byte {
+	contents := make([][]byte, 0)
+	for _, m := range scriptRefRegexp.FindAllStringSubmatch(step.Run, -1) {
+		name := path.Join(step.WorkingDirectory, m[2])
+		script, executable, err := p.readScript(name)
+		if os.IsNotExist(err) {
+			p.reportf("%s: %s does not exist", where, name)
+			continue
+		}
+		if err != nil {
+			p.reportf("%s: %v", where, err)
+			continue
+		}
+		contents = append(contents, script)
+		if m[1] == "" && !executable {
+			p.reportf("%s: %s is not executable", where, name)
+		}
+		if p.checkSyntax {
+			if err := bashSyntaxError(script); err != nil {
+				p.reportf("%s: %s has syntax errors: %v", where, name, err)
+			}
+		}
+	}
+	return contents
+}
+
+// artifactPaths returns paths uploaded by upload-artifact step.
+func artifactPaths(step actions.Step) []string {
+	a, ok := actions.ParseActionRef(step.Uses)
+	if !ok || a.Repo != actions.UploadArtifact.Repo {
+		return nil
+	}
+	res := make([]string, 0)
+	for _, line := range strings.Split(step.With["path"], "\n") {
+		line = strings.TrimSpace(line)
+		// exclusions and computed paths can not be checked
+		if line == "" || strings.HasPrefix(line, "!") || strings.Contains(line, "${{") {
+			continue
+		}
+		// only the part before the first wildcard must be produced
+		if i := strings.IndexAny(line, "*?["); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSuffix(path.Clean(line), "/")
+		if line != "." && line != "" {
+			res = append(res, line)
+		}
+	}
+	return res
+}
+
+// checkJob verifies scripts of all steps, and warns if an uploaded path is not
+// mentioned by an earlier step, is not downloaded and does not exist in the
+// repository. Paths may be produced by tools the check can not see into.
+func (p *preflight) checkJob(workflowName, jobName string, job actions.Job) {
+	// produced contains texts of previous steps, including scripts they run
+	produced := make([]string, 0)
+	for i, step := range job.Steps {
+		where := fmt.Sprintf("workflow %s, job %s, step %q", workflowName, jobName, step.Name)
+		if step.Name == "" {
+			where = fmt.Sprintf("workflow %s, job %s, step %d", workflowName, jobName, i+1)
+		}
+		for _, artifact := range artifactPaths(step) {
+			found := false
+			for _, text := range produced {
+				if strings.Contains(text, artifact) {
+					found = true
+				}
+			}
+			if !found && !fileExists(path.Join(p.repoRoot, artifact)) {
+				p.warnf("%s: uploaded path %s is not produced by earlier steps", where, artifact)
+			}
+		}
+		produced = append(produced, step.Run, step.With["path"])
+		for _, script := range p.checkScripts(where, step) {
+			produced = append(produced, string(script))
+		}
+	}
+}
+
+func (p *preflight) check(workflows []actions.Workflow) error {
+	for _, w := range workflows {
+		for _, jobName := range actions.OrderJobs(w.Jobs) {
+			p.checkJob(w.Name, jobName, w.Jobs[jobName])
+		}
+	}
+	for _, warning := range p.warnings {
+		log.Printf("warning: pre-flight: %s", warning)
+	}
+	if len(p.problems) != 0 {
+		return fmt.Errorf("pre-flight checks failed:\n  %s", strings.Join(p.problems, "\n  "))
+	}
+	return nil
+}
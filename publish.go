@@ -2,77 +2,332 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/jjs-dev/ci-config-gen/actions"
-	"github.com/jjs-dev/ci-config-gen/bors"
 	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/gating"
 )
 
-func generatePublishImageScript(config config.CiConfig) string {
-	lines := make([]string, 0)
-	lines = append(lines, "set -euxo pipefail")
-	header := `
-# GENERATED FILE DO NOT EDIT
-if [ "$GITHUB_REF" = "refs/heads/master" ]
-then
-  TAG="latest"
-elif [ "$GITHUB_REF" = "refs/heads/trying" ]
-then
-  TAG="dev"
-elif [ "$GITHUB_REF" = "refs/heads/staging" ]
-then
+// publishBranches returns branches the publish workflow is triggered on.
+func publishBranches(cfg config.CiConfig) []string {
+	branches := ciBranches(cfg)
+	for _, branch := range cfg.Tagging.TaggedBranches() {
+		if !contains(branches, branch) {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
+
+func contains(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// generateTagsScript emits shell code which puts image tags for the current
+// GITHUB_REF into TAGS variable, or exits if nothing should be published.
+func generateTagsScript(cfg config.CiConfig) []string {
+	policy := cfg.Tagging
+	lines := []string{
+		`TAGS=""`,
+		`case "$GITHUB_REF" in`,
+	}
+	for _, branch := range policy.TaggedBranches() {
+		lines = append(lines, fmt.Sprintf(`refs/heads/%s)
+  TAGS="%s"
+  ;;`, branch, policy.Branches[branch]))
+	}
+	if policy.Semver {
+		lines = append(lines, `refs/tags/v*)
+  VERSION="${GITHUB_REF#refs/tags/v}"
+  if [[ "$VERSION" =~ ^([0-9]+)\.([0-9]+)\.([0-9]+)$ ]]
+  then
+    TAGS="$VERSION ${BASH_REMATCH[1]}.${BASH_REMATCH[2]} ${BASH_REMATCH[1]}"
+  elif [[ "$VERSION" =~ ^[0-9]+\.[0-9]+\.[0-9]+-[0-9A-Za-z.-]+$ ]]
+  then
+    # pre-releases do not move floating tags
+    TAGS="$VERSION"
+  else
+    echo "tag $GITHUB_REF is not a semantic version"
+    exit 1
+  fi
+  ;;`)
+	} else if publishesOnTags(cfg) {
+		// tags are published by other jobs of the workflow
+		lines = append(lines, `refs/tags/*)
+  exit 0
+  ;;`)
+	}
+	skipped := make([]string, 0)
+	for _, branch := range ciBranches(cfg) {
+		if _, ok := policy.Branches[branch]; !ok {
+			skipped = append(skipped, "refs/heads/"+branch)
+		}
+	}
+	if len(skipped) != 0 {
+		lines = append(lines, fmt.Sprintf(`%s)
   exit 0
-else
+  ;;`, strings.Join(skipped, "|")))
+	}
+	lines = append(lines, `*)
   echo "unknown GITHUB_REF: $GITHUB_REF"
   exit 1
-fi
-echo $GITHUB_TOKEN | docker login ghcr.io -u $GITHUB_ACTOR --password-stdin`
-	lines = append(lines, header)
+  ;;
+esac`)
+	if policy.Sha {
+		lines = append(lines, `TAGS="$TAGS sha-${GITHUB_SHA::7}"`)
+	}
+	if policy.Date {
+		lines = append(lines, fmt.Sprintf(`TAGS="$TAGS $(date -u +%s)"`, policy.DateFormat))
+	}
+	return lines
+}
 
-	for _, image := range config.DockerImages {
-		lines = append(lines, fmt.Sprintf("docker tag %s ghcr.io/jjs-dev/%s:$TAG", image, image))
-		lines = append(lines, fmt.Sprintf("docker push ghcr.io/jjs-dev/%s:$TAG", image))
+func generatePublishImageScript(cfg config.CiConfig) string {
+	lines := make([]string, 0)
+	lines = append(lines, "set -euxo pipefail", "")
+	lines = append(lines, generateTagsScript(cfg)...)
+
+	for _, id := range cfg.UsedRegistries() {
+		envPrefix := config.RegistryEnvPrefix(id)
+		lines = append(lines, fmt.Sprintf(`echo "$%s_PASSWORD" | docker login %s -u "$%s_USERNAME" --password-stdin`, envPrefix, cfg.Registries[id].Host, envPrefix))
+	}
+
+	// tags of images built with buildx are passed to the build steps
+	lines = append(lines, `echo "push=true" >> "$GITHUB_OUTPUT"`)
+	lines = append(lines, `echo "created=$(date -u +%Y-%m-%dT%H:%M:%SZ)" >> "$GITHUB_OUTPUT"`)
+
+	for _, image := range cfg.DockerImages {
+		if image.BuiltByGenerator() {
+			refs := make([]string, 0)
+			for _, id := range image.Registries {
+				refs = append(refs, fmt.Sprintf(`  echo "%s:$TAG"`, cfg.Registries[id].ImageRef(image)))
+			}
+			lines = append(lines, fmt.Sprintf(`{
+echo "%s_tags<<EOF"
+for TAG in $TAGS
+do
+%s
+done
+echo "EOF"
+} >> "$GITHUB_OUTPUT"`, image.OutputKey(), strings.Join(refs, "\n")))
+			continue
+		}
+		// every push of the same image yields the same manifest digest
+		digestVar := digestEnvVar(image)
+		for _, id := range image.Registries {
+			ref := cfg.Registries[id].ImageRef(image)
+			lines = append(lines, fmt.Sprintf(`for TAG in $TAGS
+do
+  docker tag %s %s:$TAG
+  %s=$(docker push %s:$TAG | sed -n 's/.*digest: \(sha256:[0-9a-f]*\).*/\1/p')
+done`, image.Name, ref, digestVar, ref))
+		}
+		lines = append(lines, fmt.Sprintf(`echo "%s_digest=$%s" >> "$GITHUB_OUTPUT"`, image.OutputKey(), digestVar))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
-func makePublishWorkflow(root string, config config.CiConfig, bc *bors.BorsConfig) actions.Workflow {
-	publishJob := actions.Job{
-		RunsOn:  actions.UbuntuRunner,
-		If:      "github.event_name == 'push'",
-		Timeout: config.JobTimeout,
-		Env: map[string]string{
-			"GITHUB_TOKEN": "${{ secrets.GITHUB_TOKEN }}",
-		},
-		Steps: []actions.Step{
-			actions.MakeCheckoutStep(),
-			{
-				Name: "Build artifacts",
-				Run:  "bash ci/publish-build.sh",
-			},
-			{
-				Name: "Publish docker images",
-				Run:  "bash ci/publish-images.sh",
-			},
+// needsBuildScript checks if some images are built by hand-written
+// ci/publish-build.sh.
+func needsBuildScript(root string, cfg config.CiConfig) bool {
+	if _, err := os.Stat(path.Join(root, "ci/publish-build.sh")); err == nil {
+		return true
+	}
+	for _, image := range cfg.DockerImages {
+		if !image.BuiltByGenerator() {
+			return true
+		}
+	}
+	return false
+}
+
+// makeBuildxSteps returns steps building and pushing image with buildx.
+// Tags are computed by ci/publish-images.sh.
+func makeBuildxSteps(image config.DockerImage) []actions.Step {
+	buildArgs := make([]string, 0)
+	for k, v := range image.BuildArgs {
+		buildArgs = append(buildArgs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(buildArgs)
+	labels := []string{
+		"org.opencontainers.image.source=${{ github.server_url }}/${{ github.repository }}",
+		"org.opencontainers.image.revision=${{ github.sha }}",
+		"org.opencontainers.image.created=${{ steps.images.outputs.created }}",
+	}
+	with := map[string]string{
+		"context":    image.Context,
+		"file":       image.DockerfilePath(),
+		"platforms":  strings.Join(image.Platforms, ","),
+		"push":       "true",
+		"tags":       fmt.Sprintf("${{ steps.images.outputs.%s_tags }}", image.OutputKey()),
+		"labels":     strings.Join(labels, "\n"),
+		"cache-from": fmt.Sprintf("type=gha,scope=%s", image.Name),
+		"cache-to":   fmt.Sprintf("type=gha,mode=max,scope=%s", image.Name),
+	}
+	if len(buildArgs) != 0 {
+		with["build-args"] = strings.Join(buildArgs, "\n")
+	}
+	if image.Provenance {
+		// provenance is attested separately, see makeSupplyChainSteps
+		with["provenance"] = "false"
+	}
+	return []actions.Step{
+		{
+			Name: fmt.Sprintf("Build and push %s", image.Name),
+			Id:   buildStepId(image),
+			If:   "steps.images.outputs.push == 'true'",
+			Uses: actions.BuildPush.Ref(),
+			With: with,
 		},
 	}
+}
+
+// publishesOnTags checks if the publish workflow runs on `v*` tags.
+func publishesOnTags(cfg config.CiConfig) bool {
+	return cfg.Tagging.Semver || cfg.Packages.Enabled()
+}
+
+func makeImagesJob(root string, cfg config.CiConfig) actions.Job {
+	env := make(map[string]string)
+	permissions := actions.Permissions{
+		"contents": actions.PermissionRead,
+	}
+	for _, id := range cfg.UsedRegistries() {
+		registry := cfg.Registries[id]
+		envPrefix := config.RegistryEnvPrefix(id)
+		env[envPrefix+"_USERNAME"] = registry.UsernameExpr()
+		env[envPrefix+"_PASSWORD"] = registry.PasswordExpr()
+		if registry.Host == "ghcr.io" {
+			permissions["packages"] = actions.PermissionWrite
+		}
+	}
+
+	steps := []actions.Step{
+		actions.MakeCheckoutStep(),
+	}
+	useBuildx := false
+	useQemu := false
+	for _, image := range cfg.DockerImages {
+		useBuildx = useBuildx || image.BuiltByGenerator()
+		useQemu = useQemu || image.MultiPlatform()
+	}
+	if useQemu {
+		steps = append(steps, actions.Step{
+			Name: "Set up QEMU",
+			Uses: actions.SetupQemu.Ref(),
+		})
+	}
+	if useBuildx {
+		steps = append(steps, actions.Step{
+			Name: "Set up docker buildx",
+			Uses: actions.SetupBuildx.Ref(),
+		})
+	}
+	if needsBuildScript(root, cfg) {
+		steps = append(steps, actions.Step{
+			Name: "Build artifacts",
+			Run:  "bash ci/publish-build.sh",
+		})
+	}
+	steps = append(steps, actions.Step{
+		Name: "Publish docker images",
+		Id:   "images",
+		Run:  "bash ci/publish-images.sh",
+	})
+	for _, image := range cfg.DockerImages {
+		if image.BuiltByGenerator() {
+			steps = append(steps, makeBuildxSteps(image)...)
+		}
+	}
+	steps = append(steps, makeSupplyChainSteps(cfg)...)
+	outputs := make(map[string]string)
+	for _, image := range cfg.DockerImages {
+		outputs[image.OutputKey()+"-digest"] = imageDigestExpr(image)
+	}
+	for scope, level := range supplyChainPermissions(cfg) {
+		permissions[scope] = level
+	}
+
+	return actions.Job{
+		RunsOn:      cfg.Runners.Resolve("", "publish"),
+		If:          "github.event_name == 'push'",
+		Timeout:     cfg.JobTimeout,
+		Permissions: permissions,
+		Env:         env,
+		Outputs:     outputs,
+		Steps:       steps,
+	}
+}
+
+func makePublishWorkflow(root string, cfg config.CiConfig, gate gating.Gate) actions.Workflow {
+	jobs := make(map[string]actions.Job)
+	// gated is set if merges wait for some job of the workflow
+	gated := false
+	if len(cfg.DockerImages) != 0 {
+		images := makeImagesJob(root, cfg)
+		names := make([]string, 0, len(cfg.DockerImages))
+		for _, img := range cfg.DockerImages {
+			names = append(names, img.Name)
+		}
+		images.Provenance = []string{"dockerImages: " + strings.Join(names, ", ")}
+		jobs["publish"] = images
+		// images are only built on pushes, which merge queue does not use
+		if cfg.MergeGating == config.GatingBors {
+			gate.AddJob("publish")
+			gated = true
+		}
+	}
+	if cfg.Packages.Crates {
+		crates, err := makeCratesJob(root, cfg)
+		if err != nil {
+			log.Fatalf("failed to generate crates publishing job: %v", err)
+		}
+		crates.Provenance = []string{"packages.crates: true"}
+		jobs["publish-crates"] = crates
+		gate.AddJob("publish-crates")
+		gated = true
+	}
+	if cfg.Packages.GoModuleTags {
+		// runs only on tags, so it can not be required for merge
+		verify, err := makeGoModuleTagJob(root, cfg)
+		if err != nil {
+			log.Fatalf("failed to generate go module tag job: %v", err)
+		}
+		verify.Provenance = []string{"packages.goModuleTags: true"}
+		jobs["verify-go-module-tag"] = verify
+	}
+
+	var tags []string
+	if publishesOnTags(cfg) {
+		tags = []string{"v*"}
+	}
 
 	w := actions.Workflow{
 		Name: "publish",
 		On: actions.Trigger{
-			PullRequest: actions.EmptyStruct{},
-			Push: actions.PushTrigger{
-				Branches: []string{"staging", "trying", "master"},
+			PullRequest: &actions.PullRequestTrigger{},
+			Push: &actions.PushTrigger{
+				Branches: publishBranches(cfg),
+				Tags:     tags,
 			},
 		},
-		Jobs: map[string]actions.Job{
-			"publish": publishJob,
-		},
+		Permissions: actions.ReadOnlyPermissions(),
+		Jobs:        jobs,
+	}
+	// merge candidates are only tested by gated jobs
+	if gated {
+		gate.PatchTrigger(&w.On)
 	}
-
-	bc.AddJob("publish")
-
 	return w
 }
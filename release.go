@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+	"github.com/jjs-dev/ci-config-gen/languages"
+)
+
+// releaseNotesScript lists pull requests merged since the previous tag. Pull
+// request numbers are extracted from subjects of merge commits created by
+// bors ("Merge #1 #2"), GitHub ("Merge pull request #1") and squash merges.
+const releaseNotesScript = `PREV=$(git describe --tags --abbrev=0 "$GITHUB_REF_NAME^" 2>/dev/null || true)
+if [ -n "$PREV" ]
+then
+  RANGE="$PREV..$GITHUB_REF_NAME"
+  echo "## Changes since $PREV" > notes.md
+else
+  RANGE="$GITHUB_REF_NAME"
+  echo "## Changes" > notes.md
+fi
+echo >> notes.md
+for PR in $(git log --format=%s "$RANGE" | grep -oE '#[0-9]+' | tr -d '#' | sort -un)
+do
+  gh pr view "$PR" --json number,title --jq '"- \(.title) (#\(.number))"' >> notes.md || true
+done`
+
+const collectReleaseFilesScript = `mkdir -p dist
+find artifacts -type f -exec cp {} dist/ \;
+cd dist
+sha256sum * > SHA256SUMS`
+
+func makeReleaseWorkflow(langs []languages.Language, cfg config.CiConfig, repoRoot string) (actions.Workflow, bool) {
+	jobs := make(map[string]actions.Job)
+	buildJobs := make([]string, 0)
+	for _, lang := range langs {
+		if !lang.Used(repoRoot) {
+			continue
+		}
+		js := lang.Make(repoRoot, cfg)
+		for _, job := range js.Release {
+			log.Printf("Generating %s release job %s", lang.Name(), job.Name)
+			if job.RunsOn.IsZero() {
+				job.RunsOn = cfg.Runners.Resolve(lang.Name(), job.Name)
+			}
+			job.Provenance = append(job.Provenance, "release.enabled: true", fmt.Sprintf("language %s detected by %s", lang.Name(), js.Source))
+			jobs[job.Name] = job
+			buildJobs = append(buildJobs, job.Name)
+		}
+	}
+	if len(buildJobs) == 0 {
+		return actions.Workflow{}, false
+	}
+
+	jobs["release"] = actions.Job{
+		Provenance: []string{"release.enabled: true"},
+		RunsOn:     cfg.Runners.Resolve("", "release"),
+		Needs:      buildJobs,
+		Timeout:    cfg.JobTimeout,
+		Permissions: actions.Permissions{
+			"contents":      actions.PermissionWrite,
+			"pull-requests": actions.PermissionRead,
+		},
+		Env: map[string]string{
+			"GH_TOKEN": "${{ secrets.GITHUB_TOKEN }}",
+		},
+		Steps: []actions.Step{
+			{
+				Name: "Fetch sources",
+				Uses: actions.Checkout.Ref(),
+				With: map[string]string{
+					// previous tags are needed for release notes
+					"fetch-depth": "0",
+				},
+			},
+			{
+				Name: "Download binaries",
+				Uses: actions.DownloadArtifact.Ref(),
+				// binaries of all build jobs are put into single directory
+				With: map[string]string{
+					"pattern":        "release-*",
+					"path":           "artifacts",
+					"merge-multiple": "true",
+				},
+			},
+			{
+				Name: "Compute checksums",
+				Run:  collectReleaseFilesScript,
+			},
+			{
+				Name: "Assemble release notes",
+				Run:  releaseNotesScript,
+			},
+			{
+				Name: "Create release",
+				Run:  `gh release create "$GITHUB_REF_NAME" --title "$GITHUB_REF_NAME" --notes-file notes.md dist/*`,
+			},
+		},
+	}
+
+	return actions.Workflow{
+		Name: "release",
+		On: actions.Trigger{
+			Push: &actions.PushTrigger{
+				Tags: []string{"v*"},
+			},
+		},
+		Permissions: actions.ReadOnlyPermissions(),
+		Jobs:        jobs,
+	}, true
+}
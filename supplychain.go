@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jjs-dev/ci-config-gen/actions"
+	"github.com/jjs-dev/ci-config-gen/config"
+)
+
+func buildStepId(image config.DockerImage) string {
+	return "build_" + image.OutputKey()
+}
+
+// imageDigestExpr returns expression evaluating to digest of pushed image.
+func imageDigestExpr(image config.DockerImage) string {
+	if image.BuiltByGenerator() {
+		return fmt.Sprintf("${{ steps.%s.outputs.digest }}", buildStepId(image))
+	}
+	return fmt.Sprintf("${{ steps.images.outputs.%s_digest }}", image.OutputKey())
+}
+
+func digestEnvVar(image config.DockerImage) string {
+	return strings.ToUpper(image.OutputKey()) + "_DIGEST"
+}
+
+func supplyChainPermissions(cfg config.CiConfig) actions.Permissions {
+	perms := actions.Permissions{}
+	for _, image := range cfg.DockerImages {
+		if image.Sign || image.Sbom || image.Provenance {
+			// keyless signing exchanges OIDC token for a certificate
+			perms["id-token"] = actions.PermissionWrite
+		}
+		if image.Provenance {
+			perms["attestations"] = actions.PermissionWrite
+		}
+	}
+	return perms
+}
+
+// makeSupplyChainSteps returns steps generating SBOMs, signatures and
+// provenance attestations of pushed images, followed by digest summary.
+// Images are always referenced by digest, so that tags moved by concurrent
+// pushes are not signed.
+func makeSupplyChainSteps(cfg config.CiConfig) []actions.Step {
+	steps := make([]actions.Step, 0)
+	pushed := "steps.images.outputs.push == 'true'"
+	needCosign := false
+	for _, image := range cfg.DockerImages {
+		needCosign = needCosign || image.Sign || image.Sbom
+	}
+	if needCosign {
+		steps = append(steps, actions.Step{
+			Name: "Install cosign",
+			If:   pushed,
+			Uses: actions.CosignInstaller.Ref(),
+		})
+	}
+	for _, image := range cfg.DockerImages {
+		env := map[string]string{
+			"DIGEST": imageDigestExpr(image),
+		}
+		refs := make([]string, 0)
+		for _, id := range image.Registries {
+			refs = append(refs, cfg.Registries[id].ImageRef(image))
+		}
+		sbomFile := fmt.Sprintf("sbom-%s.spdx.json", image.Name)
+		if image.Sbom {
+			steps = append(steps, actions.Step{
+				Name: fmt.Sprintf("Generate SBOM for %s", image.Name),
+				If:   pushed,
+				Uses: actions.Sbom.Ref(),
+				With: map[string]string{
+					"image":       fmt.Sprintf("%s@%s", refs[0], imageDigestExpr(image)),
+					"format":      "spdx-json",
+					"output-file": sbomFile,
+				},
+			})
+		}
+		if image.Sign || image.Sbom {
+			commands := make([]string, 0)
+			for _, ref := range refs {
+				if image.Sign {
+					commands = append(commands, fmt.Sprintf(`cosign sign --yes "%s@$DIGEST"`, ref))
+				}
+				if image.Sbom {
+					commands = append(commands, fmt.Sprintf(`cosign attest --yes --type spdxjson --predicate %s "%s@$DIGEST"`, sbomFile, ref))
+				}
+			}
+			steps = append(steps, actions.Step{
+				Name: fmt.Sprintf("Sign %s", image.Name),
+				If:   pushed,
+				Env:  env,
+				Run:  strings.Join(commands, "\n"),
+			})
+		}
+		if image.Provenance {
+			for _, ref := range refs {
+				steps = append(steps, actions.Step{
+					Name: fmt.Sprintf("Attest provenance of %s", ref),
+					If:   pushed,
+					Uses: actions.AttestProvenance.Ref(),
+					With: map[string]string{
+						"subject-name":     ref,
+						"subject-digest":   imageDigestExpr(image),
+						"push-to-registry": "true",
+					},
+				})
+			}
+		}
+	}
+	if len(cfg.DockerImages) != 0 {
+		env := make(map[string]string)
+		lines := []string{
+			`echo "| Image | Digest |" >> "$GITHUB_STEP_SUMMARY"`,
+			`echo "| --- | --- |" >> "$GITHUB_STEP_SUMMARY"`,
+		}
+		for _, image := range cfg.DockerImages {
+			env[digestEnvVar(image)] = imageDigestExpr(image)
+			for _, id := range image.Registries {
+				lines = append(lines, fmt.Sprintf(`echo "| %s | $%s |" >> "$GITHUB_STEP_SUMMARY"`, cfg.Registries[id].ImageRef(image), digestEnvVar(image)))
+			}
+		}
+		steps = append(steps, actions.Step{
+			Name: "Write digests summary",
+			If:   pushed,
+			Env:  env,
+			Run:  strings.Join(lines, "\n"),
+		})
+	}
+	return steps
+}